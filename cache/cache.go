@@ -20,6 +20,8 @@ type CacheEntry struct {
 
 type Cache struct {
 	items      map[string]*list.Element
+	inflight   map[string]*call
+	inflightMu sync.Mutex
 	evictList  *list.List
 	mutex      sync.RWMutex
 	capacity   int
@@ -30,9 +32,17 @@ type Cache struct {
 }
 
 type CacheMetrics struct {
-	hits   prometheus.Counter
-	misses prometheus.Counter
-	size   prometheus.Gauge
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	size      prometheus.Gauge
+	coalesced prometheus.Counter
+}
+
+// call is a load in progress that other callers can wait on
+type call struct {
+	done  chan struct{}
+	value interface{}
+	err   error
 }
 
 var (
@@ -48,18 +58,24 @@ var (
 		Name: "cache_size",
 		Help: "Current size of the cache",
 	})
+	coalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cache_coalesced_requests_total",
+		Help: "Total number of requests that waited on an identical in-flight load",
+	})
 )
 
 func NewCache(capacity int) *Cache {
 	ctx, cancel := context.WithCancel(context.Background())
 	metrics := &CacheMetrics{
-		hits:   hits,
-		misses: misses,
-		size:   size,
+		hits:      hits,
+		misses:    misses,
+		size:      size,
+		coalesced: coalesced,
 	}
 
 	c := &Cache{
 		items:     make(map[string]*list.Element),
+		inflight:  make(map[string]*call),
 		evictList: list.New(),
 		capacity:  capacity,
 		metrics:   metrics,
@@ -122,6 +138,36 @@ func (c *Cache) Set(key string, value interface{}, ttl time.Duration) {
 	}
 }
 
+// Do runs load to produce the value for key and caches it for ttl. If a
+// load for the same key is already running, Do waits for it and returns its
+// result instead; shared reports whether that happened. Errors are returned
+// to every waiter and are not cached.
+func (c *Cache) Do(key string, ttl time.Duration, load func() (interface{}, error)) (value interface{}, shared bool, err error) {
+	c.inflightMu.Lock()
+	if existing, ok := c.inflight[key]; ok {
+		c.inflightMu.Unlock()
+		c.metrics.coalesced.Inc()
+		<-existing.done
+		return existing.value, true, existing.err
+	}
+	pending := &call{done: make(chan struct{})}
+	c.inflight[key] = pending
+	c.inflightMu.Unlock()
+
+	defer func() {
+		c.inflightMu.Lock()
+		delete(c.inflight, key)
+		c.inflightMu.Unlock()
+		close(pending.done)
+	}()
+
+	pending.value, pending.err = load()
+	if pending.err == nil {
+		c.Set(key, pending.value, ttl)
+	}
+	return pending.value, false, pending.err
+}
+
 func (c *Cache) evictLRU() {
 	element := c.evictList.Back()
 	if element != nil {
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoCoalesces(t *testing.T) {
+	errLoad := errors.New("load failed")
+	tests := []struct {
+		name       string
+		keys       []string
+		err        error
+		wantLoads  int64
+		wantCached bool
+	}{
+		{"same key", []string{"k", "k", "k", "k"}, nil, 1, true},
+		{"different keys", []string{"a", "b", "c"}, nil, 3, true},
+		{"error shared, not cached", []string{"k", "k", "k"}, errLoad, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(10)
+			defer c.Stop()
+
+			var loads int64
+			release := make(chan struct{})
+			load := func() (interface{}, error) {
+				atomic.AddInt64(&loads, 1)
+				<-release
+				return "value", tt.err
+			}
+
+			var wg sync.WaitGroup
+			var shared int64
+			for _, key := range tt.keys {
+				wg.Add(1)
+				go func(key string) {
+					defer wg.Done()
+					value, wasShared, err := c.Do(key, time.Minute, load)
+					if value != "value" || !errors.Is(err, tt.err) {
+						t.Errorf("Do(%s) = %v, %v", key, value, err)
+					}
+					if wasShared {
+						atomic.AddInt64(&shared, 1)
+					}
+				}(key)
+			}
+			// Let every caller reach Do before the load finishes
+			for !waiting(c, tt.keys) {
+				time.Sleep(time.Millisecond)
+			}
+			close(release)
+			wg.Wait()
+
+			if loads != tt.wantLoads {
+				t.Errorf("loaded %d times, want %d", loads, tt.wantLoads)
+			}
+			if want := int64(len(tt.keys)) - tt.wantLoads; shared != want {
+				t.Errorf("%d callers shared a load, want %d", shared, want)
+			}
+			for _, key := range tt.keys {
+				if _, cached := c.Get(key); cached != tt.wantCached {
+					t.Errorf("%s cached = %v, want %v", key, cached, tt.wantCached)
+				}
+			}
+		})
+	}
+}
+
+// waiting reports whether a load is in flight for every key and the
+// coalesced callers are queued behind them
+func waiting(c *Cache, keys []string) bool {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+	for _, key := range keys {
+		if _, ok := c.inflight[key]; !ok {
+			return false
+		}
+	}
+	// Callers that found the load in flight are blocked on it; give them a
+	// moment to get there
+	time.Sleep(10 * time.Millisecond)
+	return true
+}
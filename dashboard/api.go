@@ -0,0 +1,78 @@
+package dashboard
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"whatsapp-gpt-bot/whatsapp"
+)
+
+// maxAPIBody limits the size of an API request, files included
+const maxAPIBody = 32 << 20
+
+// registerAPIHandlers adds the token authenticated API other systems use to
+// send messages through the bots
+func registerAPIHandlers(am *whatsapp.AccountManager) {
+	http.HandleFunc("POST /api/bots/{id}/messages", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIBody))
+		if err != nil {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		var msg whatsapp.OutgoingMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256(body)
+
+		result, err := am.SendAPIMessage(r.Context(), token, r.PathValue("id"), r.Header.Get("Idempotency-Key"), hex.EncodeToString(sum[:]), msg)
+		if err != nil {
+			http.Error(w, err.Error(), apiErrorStatus(err))
+			return
+		}
+		writeJSON(w, result)
+	})
+}
+
+// authenticate returns the API token a request was made with. Requests
+// without a valid token are answered with 401.
+func authenticate(am *whatsapp.AccountManager, w http.ResponseWriter, r *http.Request) (whatsapp.APIToken, bool) {
+	token, ok := am.APIToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "invalid or missing API token", http.StatusUnauthorized)
+	}
+	return token, ok
+}
+
+// apiErrorStatus maps an error of SendAPIMessage to an HTTP status
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidMessage):
+		return http.StatusBadRequest
+	case errors.Is(err, whatsapp.ErrBotForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, whatsapp.ErrBotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, whatsapp.ErrRequestInProgress):
+		return http.StatusConflict
+	case errors.Is(err, whatsapp.ErrIdempotencyMismatch):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, whatsapp.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, whatsapp.ErrBotOffline):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,308 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+	"whatsapp-gpt-bot/whatsapp"
+)
+
+// registerBotHandlers adds the endpoints that inspect and manage bots
+func registerBotHandlers(am *whatsapp.AccountManager) {
+	// Usage lists contacts and resetting it lifts their quotas, both need an
+	// API token
+	http.HandleFunc("/api/usage", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+		usage := make(map[string][]storage.Usage)
+		for id := range am.ListBots() {
+			if !token.AllowsBot(id) {
+				continue
+			}
+			botUsage, err := am.Usage(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			usage[id] = botUsage
+		}
+		writeJSON(w, usage)
+	})
+
+	http.HandleFunc("/api/usage/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+		botID, contact := r.FormValue("bot_id"), r.FormValue("contact")
+		if botID == "" || contact == "" {
+			http.Error(w, "bot_id and contact are required", http.StatusBadRequest)
+			return
+		}
+		if !token.AllowsBot(botID) {
+			http.Error(w, whatsapp.ErrBotForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		if err := am.ResetUsage(botID, contact); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "reset"})
+	})
+
+	http.HandleFunc("/api/endpoints", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, am.EndpointStatuses())
+	})
+
+	http.HandleFunc("/api/rules", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, am.RuleStats())
+	})
+
+	// Scheduled messages are sent through the bots, they need an API token
+	http.HandleFunc("/api/schedule", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+		if r.Method == http.MethodPost {
+			botID, contact, text := r.FormValue("bot_id"), r.FormValue("contact"), r.FormValue("text")
+			if !token.AllowsBot(botID) {
+				http.Error(w, whatsapp.ErrBotForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			var at time.Time
+			if cron := r.FormValue("cron"); cron == "" {
+				var err error
+				at, err = time.ParseInLocation("2006-01-02T15:04", r.FormValue("at"), am.ScheduleLocation(botID))
+				if err != nil {
+					http.Error(w, "at must be YYYY-MM-DDTHH:MM, or set cron", http.StatusBadRequest)
+					return
+				}
+			}
+			id, err := am.ScheduleMessage(botID, contact, at, r.FormValue("cron"), text, "dashboard")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, map[string]int64{"id": id})
+			return
+		}
+
+		jobs := make(map[string][]storage.ScheduledMessage)
+		for id := range am.ListBots() {
+			if !token.AllowsBot(id) {
+				continue
+			}
+			botJobs, err := am.ScheduledMessages(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jobs[id] = botJobs
+		}
+		writeJSON(w, jobs)
+	})
+
+	http.HandleFunc("/api/schedule/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+		if !token.AllowsBot(r.FormValue("bot_id")) {
+			http.Error(w, whatsapp.ErrBotForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		if err := am.CancelScheduled(r.FormValue("bot_id"), id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "cancelled"})
+	})
+
+	// Campaigns send messages to many contacts, they need an API token
+	// like the messages API
+	http.HandleFunc("/api/campaigns", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+		if r.Method == http.MethodPost {
+			botID := r.FormValue("bot_id")
+			if !token.AllowsBot(botID) {
+				http.Error(w, whatsapp.ErrBotForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			var recipients []storage.Recipient
+			var err error
+			if tag := r.FormValue("tag"); tag != "" {
+				recipients, err = am.TaggedRecipients(botID, tag)
+			} else {
+				file, _, ferr := r.FormFile("recipients")
+				if ferr != nil {
+					http.Error(w, "a recipients CSV file or a tag is required", http.StatusBadRequest)
+					return
+				}
+				recipients, err = whatsapp.ReadRecipientsCSV(file)
+				file.Close()
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			id, err := am.CreateCampaign(botID, r.FormValue("name"), r.FormValue("template"), recipients)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if r.FormValue("start") == "true" {
+				if err := am.StartCampaign(id); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			writeJSON(w, map[string]interface{}{"id": id, "recipients": len(recipients)})
+			return
+		}
+
+		all, err := am.Campaigns(r.URL.Query().Get("bot_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		campaigns := make([]storage.Campaign, 0, len(all))
+		for _, c := range all {
+			if token.AllowsBot(c.BotID) {
+				campaigns = append(campaigns, c)
+			}
+		}
+		writeJSON(w, campaigns)
+	})
+
+	http.HandleFunc("/api/campaigns/report", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		campaign, recipients, err := am.CampaignReport(id, r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if !token.AllowsBot(campaign.BotID) {
+			http.Error(w, whatsapp.ErrBotForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"campaign": campaign, "recipients": recipients})
+	})
+
+	// Campaign controls: start (also resumes), pause and cancel
+	controls := map[string]func(int64) error{
+		"start":  am.StartCampaign,
+		"pause":  am.PauseCampaign,
+		"cancel": am.CancelCampaign,
+	}
+	for action, control := range controls {
+		action, control := action, control
+		http.HandleFunc("/api/campaigns/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			token, ok := authenticate(am, w, r)
+			if !ok {
+				return
+			}
+			id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "id is required", http.StatusBadRequest)
+				return
+			}
+			campaign, err := am.Campaign(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if !token.AllowsBot(campaign.BotID) {
+				http.Error(w, whatsapp.ErrBotForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			if err := control(id); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeJSON(w, map[string]string{"status": action})
+		})
+	}
+
+	http.HandleFunc("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+		botID := r.FormValue("bot_id")
+		if !token.AllowsBot(botID) {
+			http.Error(w, whatsapp.ErrBotForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		tagged := r.FormValue("remove") != "true"
+		if err := am.TagContact(botID, r.FormValue("contact"), r.FormValue("tag"), tagged); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]bool{"tagged": tagged})
+	})
+
+	// The audit log holds owner commands and their replies
+	http.HandleFunc("/api/audit", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := authenticate(am, w, r)
+		if !ok {
+			return
+		}
+		audit := make(map[string][]storage.AuditEntry)
+		for id := range am.ListBots() {
+			if !token.AllowsBot(id) {
+				continue
+			}
+			entries, err := am.AuditLog(id, 100)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			audit[id] = entries
+		}
+		writeJSON(w, audit)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"whatsapp-gpt-bot/utils"
+	"whatsapp-gpt-bot/whatsapp"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -51,9 +52,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 }
 
 // Start initializes and starts the metrics dashboard server
-func Start() error {
+func Start(am *whatsapp.AccountManager) error {
 	port := defaultPort
 
+	registerBotHandlers(am)
+	registerAPIHandlers(am)
+
 	// Register metrics handlers
 	http.Handle("/metrics", promhttp.Handler())
 		http.HandleFunc("/dashboard-metrics", func(w http.ResponseWriter, r *http.Request) {
@@ -70,6 +74,9 @@ func Start() error {
 			"lm_studio": lmMetrics,
 			"timeouts":  timeoutMetrics,
 			"memory":    memStats,
+			"breakers":  utils.GetBreakerStatuses(),
+			"latency":   am.TimeoutStats(),
+			"rules":     am.RuleStats(),
 			"timestamp": time.Now(),
 		}
 
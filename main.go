@@ -9,10 +9,13 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"whatsapp-gpt-bot/dashboard"
+	"whatsapp-gpt-bot/storage"
 	"whatsapp-gpt-bot/whatsapp"
 
 	waLog "go.mau.fi/whatsmeow/util/log"
@@ -20,20 +23,14 @@ import (
 )
 
 const (
-	LOG_FILE = "whatsapp-bot.log"
-	DB_PATH  = "file:whatsapp.db?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&cache=shared&mode=rwc"
+	LOG_FILE    = "whatsapp-bot.log"
+	DB_PATH     = "file:whatsapp.db?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&cache=shared&mode=rwc"
+	CONFIG_PATH = "config.json"
 )
 
 func main() {
 	fmt.Println("Starting WhatsApp bot manager...")
 
-	// Initialize and start the metrics dashboard
-	if err := dashboard.Start(); err != nil {
-		fmt.Printf("Failed to start metrics dashboard: %v\n", err)
-		return
-	}
-	fmt.Println("Performance dashboard initialized...")
-
 	logFile, err := setupLogging()
 	if err != nil {
 		fmt.Printf("Failed to set up logging: %v\n", err)
@@ -44,13 +41,20 @@ func main() {
 	logger := waLog.Stdout("Bot", "INFO", true)
 	fmt.Println("Logger initialized...")
 
-	accountManager, err := whatsapp.NewAccountManager(DB_PATH, logger)
+	accountManager, err := whatsapp.NewAccountManager(DB_PATH, CONFIG_PATH, logger)
 	if err != nil {
 		logger.Errorf("Failed to create account manager: %v", err)
 		return
 	}
 	defer accountManager.Close()
 
+	// Initialize and start the metrics dashboard
+	if err := dashboard.Start(accountManager); err != nil {
+		fmt.Printf("Failed to start metrics dashboard: %v\n", err)
+		return
+	}
+	fmt.Println("Performance dashboard initialized...")
+
 	if err := accountManager.LoadBots(); err != nil {
 		logger.Errorf("Failed to load existing bots: %v", err)
 	}
@@ -81,7 +85,21 @@ func handleCommands(am *whatsapp.AccountManager, logger waLog.Logger) {
 		fmt.Println("1. new - Create new bot instance")
 		fmt.Println("2. list - List all active bots")
 		fmt.Println("3. remove <bot_id> - Remove a bot instance")
-		fmt.Println("4. quit - Exit the application")
+		fmt.Println("4. usage <bot_id> [contact] - Show contact usage")
+		fmt.Println("5. reset-usage <bot_id> <contact> - Reset a contact's usage")
+		fmt.Println("6. access <bot_id> - Show contacts' access and consent status")
+		fmt.Println("7. allow|revoke <bot_id> <contact> - Allow a contact or forget its status")
+		fmt.Println("8. block|unblock <bot_id> <contact> - Block or unblock a contact")
+		fmt.Println("9. schedule <bot_id> <contact> <YYYY-MM-DDTHH:MM> <text> - Send a message later")
+		fmt.Println("10. schedule-cron <bot_id> <contact> <min> <hour> <day> <month> <weekday> <text> - Send a message on a schedule")
+		fmt.Println("11. jobs <bot_id> - List scheduled messages")
+		fmt.Println("12. unschedule <bot_id> <id> - Cancel a scheduled message")
+		fmt.Println("13. tag|untag <bot_id> <contact> <tag> - Tag a contact for campaigns")
+		fmt.Println("14. campaign <bot_id> <recipients.csv|tag:name> <template_file> [name] - Create a broadcast campaign")
+		fmt.Println("15. campaigns [bot_id] - List campaigns")
+		fmt.Println("16. start|pause|resume|cancel <campaign_id> - Control a campaign")
+		fmt.Println("17. report <campaign_id> [status] - Show a campaign's delivery report")
+		fmt.Println("18. quit - Exit the application")
 		fmt.Print("\nEnter command: ")
 
 		command, _ := reader.ReadString('\n')
@@ -136,6 +154,295 @@ func handleCommands(am *whatsapp.AccountManager, logger waLog.Logger) {
 				logger.Infof("Bot %s removed successfully", args[1])
 			}
 
+		case "usage":
+			if len(args) < 2 {
+				logger.Warnf("Please specify bot ID")
+				continue
+			}
+
+			if len(args) >= 3 {
+				usage, err := am.ContactUsage(args[1], args[2])
+				if err != nil {
+					logger.Errorf("Error reading usage: %v", err)
+					continue
+				}
+				logger.Infof("%s: today %d messages / %d tokens, this month %d messages / %d tokens",
+					usage.Contact, usage.DailyMessages, usage.DailyTokens, usage.MonthlyMessages, usage.MonthlyTokens)
+				continue
+			}
+
+			usages, err := am.Usage(args[1])
+			if err != nil {
+				logger.Errorf("Error reading usage: %v", err)
+				continue
+			}
+			if len(usages) == 0 {
+				logger.Infof("No usage recorded this month")
+				continue
+			}
+			for _, usage := range usages {
+				logger.Infof("- %s: today %d messages / %d tokens, this month %d messages / %d tokens",
+					usage.Contact, usage.DailyMessages, usage.DailyTokens, usage.MonthlyMessages, usage.MonthlyTokens)
+			}
+
+		case "reset-usage":
+			if len(args) < 3 {
+				logger.Warnf("Please specify bot ID and contact")
+				continue
+			}
+
+			if err := am.ResetUsage(args[1], args[2]); err != nil {
+				logger.Errorf("Error resetting usage: %v", err)
+			} else {
+				logger.Infof("Usage of %s reset", args[2])
+			}
+
+		case "access":
+			if len(args) < 2 {
+				logger.Warnf("Please specify bot ID")
+				continue
+			}
+
+			entries, err := am.AccessList(args[1])
+			if err != nil {
+				logger.Errorf("Error reading access list: %v", err)
+				continue
+			}
+			if len(entries) == 0 {
+				logger.Infof("No contacts with an access status")
+				continue
+			}
+			for _, e := range entries {
+				logger.Infof("- %s: %s since %s", e.Contact, e.Status, e.UpdatedAt.Format("2006-01-02 15:04"))
+			}
+
+		case "allow", "revoke":
+			if len(args) < 3 {
+				logger.Warnf("Please specify bot ID and contact")
+				continue
+			}
+
+			var err error
+			if args[0] == "allow" {
+				err = am.SetContactAccess(args[1], args[2], whatsapp.ContactAllowed)
+			} else {
+				err = am.RevokeAccess(args[1], args[2])
+			}
+			if err != nil {
+				logger.Errorf("Error updating access: %v", err)
+			} else {
+				logger.Infof("Access of %s updated", args[2])
+			}
+
+		case "block", "unblock":
+			if len(args) < 3 {
+				logger.Warnf("Please specify bot ID and contact")
+				continue
+			}
+
+			if err := am.SetBlocked(args[1], args[2], args[0] == "block"); err != nil {
+				logger.Errorf("Error updating block list: %v", err)
+			} else {
+				logger.Infof("%s %sed", args[2], args[0])
+			}
+
+		case "schedule":
+			if len(args) < 5 {
+				logger.Warnf("Please specify bot ID, contact, time and text")
+				continue
+			}
+
+			at, err := time.ParseInLocation("2006-01-02T15:04", args[3], am.ScheduleLocation(args[1]))
+			if err != nil {
+				logger.Errorf("Invalid time %s, use YYYY-MM-DDTHH:MM", args[3])
+				continue
+			}
+			id, err := am.ScheduleMessage(args[1], args[2], at, "", strings.Join(args[4:], " "), "cli")
+			if err != nil {
+				logger.Errorf("Error scheduling message: %v", err)
+			} else {
+				logger.Infof("Message %d scheduled for %s", id, at.Format(time.RFC1123))
+			}
+
+		case "schedule-cron":
+			if len(args) < 9 {
+				logger.Warnf("Please specify bot ID, contact, the five cron fields and text")
+				continue
+			}
+
+			id, err := am.ScheduleMessage(args[1], args[2], time.Time{}, strings.Join(args[3:8], " "), strings.Join(args[8:], " "), "cli")
+			if err != nil {
+				logger.Errorf("Error scheduling message: %v", err)
+			} else {
+				logger.Infof("Recurring message %d scheduled", id)
+			}
+
+		case "jobs":
+			if len(args) < 2 {
+				logger.Warnf("Please specify bot ID")
+				continue
+			}
+
+			jobs, err := am.ScheduledMessages(args[1])
+			if err != nil {
+				logger.Errorf("Error reading scheduled messages: %v", err)
+				continue
+			}
+			if len(jobs) == 0 {
+				logger.Infof("No scheduled messages")
+				continue
+			}
+			for _, job := range jobs {
+				recurring := ""
+				if job.Cron != "" {
+					recurring = " (" + job.Cron + ")"
+				}
+				logger.Infof("- %d: %s %s to %s%s: %s", job.ID, job.Kind, job.NextRun.Format("2006-01-02 15:04"), job.Chat, recurring, job.Text)
+			}
+
+		case "unschedule":
+			if len(args) < 3 {
+				logger.Warnf("Please specify bot ID and message ID")
+				continue
+			}
+
+			id, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				logger.Errorf("Invalid message ID %s", args[2])
+				continue
+			}
+			if err := am.CancelScheduled(args[1], id); err != nil {
+				logger.Errorf("Error cancelling message: %v", err)
+			} else {
+				logger.Infof("Message %d cancelled", id)
+			}
+
+		case "tag", "untag":
+			if len(args) < 4 {
+				logger.Warnf("Please specify bot ID, contact and tag")
+				continue
+			}
+
+			if err := am.TagContact(args[1], args[2], args[3], args[0] == "tag"); err != nil {
+				logger.Errorf("Error updating tags: %v", err)
+			} else {
+				logger.Infof("%s %sged %s", args[2], args[0], args[3])
+			}
+
+		case "campaign":
+			if len(args) < 4 {
+				logger.Warnf("Please specify bot ID, recipients and template file")
+				continue
+			}
+
+			var recipients []storage.Recipient
+			var err error
+			if tag, ok := strings.CutPrefix(args[2], "tag:"); ok {
+				recipients, err = am.TaggedRecipients(args[1], tag)
+			} else {
+				var file *os.File
+				if file, err = os.Open(args[2]); err == nil {
+					recipients, err = whatsapp.ReadRecipientsCSV(file)
+					file.Close()
+				}
+			}
+			if err != nil {
+				logger.Errorf("Error reading recipients: %v", err)
+				continue
+			}
+			text, err := os.ReadFile(args[3])
+			if err != nil {
+				logger.Errorf("Error reading template: %v", err)
+				continue
+			}
+			id, err := am.CreateCampaign(args[1], strings.Join(args[4:], " "), string(text), recipients)
+			if err != nil {
+				logger.Errorf("Error creating campaign: %v", err)
+			} else {
+				logger.Infof("Campaign %d created with %d recipients, use start %d to send it", id, len(recipients), id)
+			}
+
+		case "campaigns":
+			botID := ""
+			if len(args) > 1 {
+				botID = args[1]
+			}
+
+			campaigns, err := am.Campaigns(botID)
+			if err != nil {
+				logger.Errorf("Error reading campaigns: %v", err)
+				continue
+			}
+			if len(campaigns) == 0 {
+				logger.Infof("No campaigns")
+				continue
+			}
+			for _, c := range campaigns {
+				logger.Infof("- %d: %s (%s) by %s: %d queued, %d sent, %d replied, %d failed", c.ID, c.Name, c.Status, c.BotID,
+					c.Counts[storage.RecipientQueued], c.Counts[storage.RecipientSent], c.Counts[storage.RecipientReplied], c.Counts[storage.RecipientFailed])
+			}
+
+		case "start", "pause", "resume", "cancel":
+			if len(args) < 2 {
+				logger.Warnf("Please specify campaign ID")
+				continue
+			}
+
+			id, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				logger.Errorf("Invalid campaign ID %s", args[1])
+				continue
+			}
+			done := ""
+			switch args[0] {
+			case "start":
+				err, done = am.StartCampaign(id), "started"
+			case "resume":
+				err, done = am.StartCampaign(id), "resumed"
+			case "pause":
+				err, done = am.PauseCampaign(id), "paused"
+			case "cancel":
+				err, done = am.CancelCampaign(id), "cancelled"
+			}
+			if err != nil {
+				logger.Errorf("Error updating campaign: %v", err)
+			} else {
+				logger.Infof("Campaign %d %s", id, done)
+			}
+
+		case "report":
+			if len(args) < 2 {
+				logger.Warnf("Please specify campaign ID")
+				continue
+			}
+
+			id, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				logger.Errorf("Invalid campaign ID %s", args[1])
+				continue
+			}
+			status := ""
+			if len(args) > 2 {
+				status = args[2]
+			}
+			c, recipients, err := am.CampaignReport(id, status)
+			if err != nil {
+				logger.Errorf("Error reading campaign: %v", err)
+				continue
+			}
+			logger.Infof("Campaign %d %s (%s): %d queued, %d sent, %d replied, %d failed", c.ID, c.Name, c.Status,
+				c.Counts[storage.RecipientQueued], c.Counts[storage.RecipientSent], c.Counts[storage.RecipientReplied], c.Counts[storage.RecipientFailed])
+			for _, r := range recipients {
+				line := "- " + r.Contact + ": " + r.Status
+				if !r.SentAt.IsZero() {
+					line += " " + r.SentAt.Format("2006-01-02 15:04")
+				}
+				if r.Error != "" {
+					line += " (" + r.Error + ")"
+				}
+				logger.Infof("%s", line)
+			}
+
 		case "quit":
 			logger.Infof("Shutting down...")
 			am.DisconnectAll()
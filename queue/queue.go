@@ -3,6 +3,7 @@ package queue
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"whatsapp-gpt-bot/types"
@@ -11,18 +12,64 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Handler processes a single message taken off the queue
+type Handler func(msg types.Message)
+
+// DefaultLaneWeights controls how many messages each lane gets scheduled
+// relative to the others when all of them have work waiting
+var DefaultLaneWeights = map[types.Priority]int{
+	types.PriorityOwner:  8,
+	types.PriorityVIP:    4,
+	types.PriorityNormal: 2,
+	types.PriorityBulk:   1,
+}
+
+var workerConcurrency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "message_queue_worker_concurrency",
+	Help: "Current number of workers a queue may run concurrently",
+}, []string{"queue_id"})
+
+// Lane metrics are exported with the default registry, per queue and lane
+var (
+	laneLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "message_queue_lane_length",
+		Help: "Current number of messages waiting in each priority lane",
+	}, []string{"queue_id", "lane"})
+	laneWaitTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "message_queue_wait_time_seconds",
+		Help:    "Time messages spend waiting in each priority lane",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"queue_id", "lane"})
+	laneProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_queue_lane_processed_total",
+		Help: "Total number of processed messages per priority lane",
+	}, []string{"queue_id", "lane"})
+)
+
 type MessageBatch struct {
 	Type     types.MessageType
 	Messages []types.Message
 }
 
+// lane holds the pending messages of one priority class
+type lane struct {
+	priority types.Priority
+	weight   int
+	current  int
+	pending  []types.Message
+	processed int64
+}
+
 type Queue struct {
-	messages    chan types.Message
+	id          string
 	workerPool  *WorkerPool
 	batchSize   int
 	batchWindow time.Duration
-	batches     map[types.MessageType]*MessageBatch
-	batchMutex  sync.RWMutex
+	lanes       []*lane
+	laneMutex   sync.Mutex
+	notify      chan struct{}
+	handler     Handler
+	handlerMux  sync.RWMutex
 	metrics     *QueueMetrics
 }
 
@@ -31,54 +78,157 @@ type QueueMetrics struct {
 	processingTime prometheus.Histogram
 	messagesProcessed prometheus.Counter
 	batchSize prometheus.Histogram
+	laneLength *prometheus.GaugeVec
+	laneWaitTime *prometheus.HistogramVec
+	laneProcessed *prometheus.CounterVec
+}
+
+// LaneStats is a point-in-time view of one priority lane
+type LaneStats struct {
+	Pending   int
+	Processed int64
+	Weight    int
 }
 
 func NewQueue(numWorkers, batchSize int, batchWindow time.Duration) *Queue {
+	id := fmt.Sprintf("queue_%d", time.Now().UnixNano())
+
 	// Create a unique registry for this queue instance
 	reg := prometheus.NewRegistry()
 	factory := promauto.With(reg)
+	labels := prometheus.Labels{"queue_id": id}
 
 	metrics := &QueueMetrics{
 		queueLength: factory.NewGauge(prometheus.GaugeOpts{
 			Name: "message_queue_length",
 			Help: "Current number of messages in queue",
-			ConstLabels: prometheus.Labels{"queue_id": fmt.Sprintf("queue_%d", time.Now().UnixNano())},
+			ConstLabels: labels,
 		}),
 		processingTime: factory.NewHistogram(prometheus.HistogramOpts{
 			Name:    "message_processing_time_seconds",
 			Help:    "Time taken to process messages",
 			Buckets: prometheus.DefBuckets,
-			ConstLabels: prometheus.Labels{"queue_id": fmt.Sprintf("queue_%d", time.Now().UnixNano())},
+			ConstLabels: labels,
 		}),
 		messagesProcessed: factory.NewCounter(prometheus.CounterOpts{
 			Name: "messages_processed_total",
 			Help: "Total number of processed messages",
-			ConstLabels: prometheus.Labels{"queue_id": fmt.Sprintf("queue_%d", time.Now().UnixNano())},
+			ConstLabels: labels,
 		}),
 		batchSize: factory.NewHistogram(prometheus.HistogramOpts{
 			Name:    "message_batch_size",
 			Help:    "Size of message batches",
 			Buckets: []float64{1, 2, 5, 10, 20, 50},
-			ConstLabels: prometheus.Labels{"queue_id": fmt.Sprintf("queue_%d", time.Now().UnixNano())},
+			ConstLabels: labels,
 		}),
+		laneLength:    laneLength.MustCurryWith(labels),
+		laneWaitTime:  laneWaitTime.MustCurryWith(labels).(*prometheus.HistogramVec),
+		laneProcessed: laneProcessed.MustCurryWith(labels),
 	}
 
 	q := &Queue{
-		messages:    make(chan types.Message, 1000),
+		id:          id,
 		workerPool:  NewWorkerPool(numWorkers),
 		batchSize:   batchSize,
 		batchWindow: batchWindow,
-		batches:     make(map[types.MessageType]*MessageBatch),
+		notify:      make(chan struct{}, 1),
 		metrics:     metrics,
 	}
+	for _, p := range types.Priorities {
+		q.lanes = append(q.lanes, &lane{priority: p, weight: DefaultLaneWeights[p]})
+	}
+
+	q.workerPool.OnResize(func(limit int) {
+		workerConcurrency.WithLabelValues(q.id).Set(float64(limit))
+	})
 
 	go q.batchProcessor()
 	return q
 }
 
+// SetWorkerBounds lets the worker pool resize itself between min and max
+// workers based on the latencies reported through ObserveLatency
+func (q *Queue) SetWorkerBounds(min, max int) {
+	q.workerPool.SetBounds(min, max)
+}
+
+// ObserveLatency reports how long an upstream (LLM) request took and
+// whether it failed, so the worker pool can adapt its concurrency
+func (q *Queue) ObserveLatency(latency time.Duration, err error) {
+	q.workerPool.Observe(latency, err)
+}
+
+// Concurrency returns the worker pool's current limit and busy workers
+func (q *Queue) Concurrency() (limit, active int) {
+	return q.workerPool.Concurrency()
+}
+
+// SetHandler sets the function that processes dequeued messages. Messages
+// are only counted, not processed, until a handler is set.
+func (q *Queue) SetHandler(handler Handler) {
+	q.handlerMux.Lock()
+	defer q.handlerMux.Unlock()
+	q.handler = handler
+}
+
+// SetLaneWeight changes the scheduling weight of a priority lane
+func (q *Queue) SetLaneWeight(priority types.Priority, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	q.laneMutex.Lock()
+	defer q.laneMutex.Unlock()
+	for _, l := range q.lanes {
+		if l.priority == priority {
+			l.weight = weight
+		}
+	}
+}
+
 func (q *Queue) Enqueue(msg types.Message) {
-	q.messages <- msg
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	q.laneMutex.Lock()
+	l := q.lane(msg.Priority)
+	l.pending = append(l.pending, msg)
+	q.laneMutex.Unlock()
+
 	q.metrics.queueLength.Inc()
+	q.metrics.laneLength.WithLabelValues(msg.Priority.String()).Inc()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// LaneStats returns the current state of every priority lane
+func (q *Queue) LaneStats() map[string]LaneStats {
+	q.laneMutex.Lock()
+	defer q.laneMutex.Unlock()
+
+	stats := make(map[string]LaneStats, len(q.lanes))
+	for _, l := range q.lanes {
+		stats[l.priority.String()] = LaneStats{
+			Pending:   len(l.pending),
+			Processed: atomic.LoadInt64(&l.processed),
+			Weight:    l.weight,
+		}
+	}
+	return stats
+}
+
+// lane returns the lane for a priority, falling back to the normal lane.
+// Callers must hold laneMutex.
+func (q *Queue) lane(priority types.Priority) *lane {
+	for _, l := range q.lanes {
+		if l.priority == priority {
+			return l
+		}
+	}
+	return q.lane(types.PriorityNormal)
 }
 
 func (q *Queue) batchProcessor() {
@@ -86,52 +236,88 @@ func (q *Queue) batchProcessor() {
 	defer ticker.Stop()
 
 	for {
+		if batch := q.nextBatch(); batch != nil {
+			q.processBatch(batch)
+			continue
+		}
+
 		select {
-		case msg := <-q.messages:
-			q.addToBatch(msg)
+		case <-q.notify:
 		case <-ticker.C:
-			q.processBatches()
 		}
 	}
 }
 
-func (q *Queue) addToBatch(msg types.Message) {
-	q.batchMutex.Lock()
-	defer q.batchMutex.Unlock()
+// nextBatch picks the next lane using smooth weighted round-robin over the
+// lanes that have work, and takes up to batchSize messages from it
+func (q *Queue) nextBatch() *MessageBatch {
+	q.laneMutex.Lock()
+	defer q.laneMutex.Unlock()
 
-	if batch, exists := q.batches[msg.Type]; exists {
-		batch.Messages = append(batch.Messages, msg)
-		if len(batch.Messages) >= q.batchSize {
-			q.processBatch(msg.Type)
+	var selected *lane
+	total := 0
+	for _, l := range q.lanes {
+		if len(l.pending) == 0 {
+			continue
 		}
-	} else {
-		q.batches[msg.Type] = &MessageBatch{
-			Type:     msg.Type,
-			Messages: []types.Message{msg},
+		l.current += l.weight
+		total += l.weight
+		if selected == nil || l.current > selected.current {
+			selected = l
 		}
 	}
-}
-
-func (q *Queue) processBatches() {
-	q.batchMutex.Lock()
-	defer q.batchMutex.Unlock()
+	if selected == nil {
+		return nil
+	}
+	selected.current -= total
 
-	for msgType := range q.batches {
-		q.processBatch(msgType)
+	n := q.batchSize
+	if n < 1 || n > len(selected.pending) {
+		n = len(selected.pending)
+	}
+	batch := &MessageBatch{
+		Type:     selected.pending[0].Type,
+		Messages: append([]types.Message(nil), selected.pending[:n]...),
+	}
+	selected.pending = selected.pending[n:]
+	if len(selected.pending) == 0 {
+		// An idle lane must not bank credit for later
+		selected.current = 0
+		selected.pending = nil
 	}
+	return batch
 }
 
-func (q *Queue) processBatch(msgType types.MessageType) {
-	if batch, exists := q.batches[msgType]; exists && len(batch.Messages) > 0 {
-		start := time.Now()
+func (q *Queue) processBatch(batch *MessageBatch) {
+	q.metrics.batchSize.Observe(float64(len(batch.Messages)))
+
+	q.handlerMux.RLock()
+	handler := q.handler
+	q.handlerMux.RUnlock()
+
+	q.laneMutex.Lock()
+	l := q.lane(batch.Messages[0].Priority)
+	q.laneMutex.Unlock()
+
+	for _, msg := range batch.Messages {
+		msg := msg
+		laneName := msg.Priority.String()
+
+		// Submit blocks while all workers are busy, so the lanes keep their
+		// order until a worker frees up
 		q.workerPool.Submit(func() {
-			for range batch.Messages {
-				q.metrics.queueLength.Dec()
-				q.metrics.messagesProcessed.Inc()
+			q.metrics.queueLength.Dec()
+			q.metrics.laneLength.WithLabelValues(laneName).Dec()
+			q.metrics.laneWaitTime.WithLabelValues(laneName).Observe(time.Since(msg.Timestamp).Seconds())
+
+			start := time.Now()
+			if handler != nil {
+				handler(msg)
 			}
-			q.metrics.batchSize.Observe(float64(len(batch.Messages)))
+			q.metrics.messagesProcessed.Inc()
+			q.metrics.laneProcessed.WithLabelValues(laneName).Inc()
+			atomic.AddInt64(&l.processed, 1)
 			q.metrics.processingTime.Observe(time.Since(start).Seconds())
 		})
-		delete(q.batches, msgType)
 	}
-}
\ No newline at end of file
+}
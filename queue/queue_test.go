@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"testing"
+
+	"whatsapp-gpt-bot/types"
+)
+
+// newTestQueue returns a queue without a batch processor, so batches are
+// only taken by the test
+func newTestQueue(batchSize int, pending map[types.Priority]int) *Queue {
+	q := &Queue{batchSize: batchSize}
+	for _, p := range types.Priorities {
+		l := &lane{priority: p, weight: DefaultLaneWeights[p]}
+		for i := 0; i < pending[p]; i++ {
+			l.pending = append(l.pending, types.Message{Priority: p})
+		}
+		q.lanes = append(q.lanes, l)
+	}
+	return q
+}
+
+func TestNextBatchWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending map[types.Priority]int
+		batches int
+		want    map[types.Priority]int
+	}{
+		{
+			"all lanes busy",
+			map[types.Priority]int{types.PriorityOwner: 100, types.PriorityVIP: 100, types.PriorityNormal: 100, types.PriorityBulk: 100},
+			15,
+			map[types.Priority]int{types.PriorityOwner: 8, types.PriorityVIP: 4, types.PriorityNormal: 2, types.PriorityBulk: 1},
+		},
+		{
+			"normal and bulk",
+			map[types.Priority]int{types.PriorityNormal: 100, types.PriorityBulk: 100},
+			9,
+			map[types.Priority]int{types.PriorityNormal: 6, types.PriorityBulk: 3},
+		},
+		{
+			"drained lane yields",
+			map[types.Priority]int{types.PriorityOwner: 2, types.PriorityBulk: 100},
+			6,
+			map[types.Priority]int{types.PriorityOwner: 2, types.PriorityBulk: 4},
+		},
+		{
+			"empty",
+			nil,
+			3,
+			map[types.Priority]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(1, tt.pending)
+			got := make(map[types.Priority]int)
+			for i := 0; i < tt.batches; i++ {
+				batch := q.nextBatch()
+				if batch == nil {
+					continue
+				}
+				got[batch.Messages[0].Priority] += len(batch.Messages)
+			}
+			for _, p := range types.Priorities {
+				if got[p] != tt.want[p] {
+					t.Errorf("%s lane got %d messages, want %d", p, got[p], tt.want[p])
+				}
+			}
+		})
+	}
+}
+
+func TestNextBatchSize(t *testing.T) {
+	tests := []struct {
+		batchSize, pending int
+		want               []int
+	}{
+		{3, 7, []int{3, 3, 1}},
+		{10, 4, []int{4}},
+		{0, 5, []int{5}},
+	}
+	for _, tt := range tests {
+		q := newTestQueue(tt.batchSize, map[types.Priority]int{types.PriorityNormal: tt.pending})
+		var got []int
+		for batch := q.nextBatch(); batch != nil; batch = q.nextBatch() {
+			got = append(got, len(batch.Messages))
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("batch size %d: got batches %v, want %v", tt.batchSize, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("batch size %d: got batches %v, want %v", tt.batchSize, got, tt.want)
+				break
+			}
+		}
+	}
+}
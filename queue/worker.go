@@ -2,26 +2,163 @@ package queue
 
 import (
 	"sync"
+	"time"
 )
 
+const (
+	// latencyTolerance is how far above the baseline latency a request may
+	// take before it counts as a sign of overload
+	latencyTolerance = 2.0
+	// decreaseFactor is the multiplicative decrease applied on overload
+	decreaseFactor = 0.75
+	// baselineDecay lets the baseline drift up slowly so a single lucky
+	// fast response doesn't pin it forever
+	baselineDecay = 1.01
+)
+
+// WorkerPool runs tasks with a bounded number of concurrent workers. The
+// bound adapts between min and max using AIMD: it grows by one worker per
+// window of healthy requests and shrinks multiplicatively when requests fail
+// or get much slower than the best latency seen.
 type WorkerPool struct {
-	workers chan struct{}
-	wg      sync.WaitGroup
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	wg       sync.WaitGroup
+	min      int
+	max      int
+	limit    int
+	active   int
+	stopped  bool
+	baseline time.Duration
+	healthy  int
+	onResize func(limit int)
 }
 
 func NewWorkerPool(size int) *WorkerPool {
-	return &WorkerPool{
-		workers: make(chan struct{}, size),
+	return NewAdaptiveWorkerPool(size, size)
+}
+
+// NewAdaptiveWorkerPool creates a pool that starts at max workers and
+// adjusts itself between min and max
+func NewAdaptiveWorkerPool(min, max int) *WorkerPool {
+	p := &WorkerPool{}
+	p.cond = sync.NewCond(&p.mutex)
+	p.SetBounds(min, max)
+	return p
+}
+
+// SetBounds changes the range the pool may resize within
+func (p *WorkerPool) SetBounds(min, max int) {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+
+	p.mutex.Lock()
+	p.min = min
+	p.max = max
+	if p.limit == 0 || p.limit > max {
+		p.limit = max
+	}
+	if p.limit < min {
+		p.limit = min
+	}
+	limit := p.limit
+	onResize := p.onResize
+	p.mutex.Unlock()
+
+	p.cond.Broadcast()
+	if onResize != nil {
+		onResize(limit)
+	}
+}
+
+// OnResize registers a callback that is invoked with the new limit whenever
+// the pool's concurrency changes
+func (p *WorkerPool) OnResize(fn func(limit int)) {
+	p.mutex.Lock()
+	p.onResize = fn
+	limit := p.limit
+	p.mutex.Unlock()
+
+	if fn != nil {
+		fn(limit)
 	}
 }
 
+// Observe feeds the outcome of one upstream request into the controller
+func (p *WorkerPool) Observe(latency time.Duration, err error) {
+	p.mutex.Lock()
+
+	old := p.limit
+	overloaded := err != nil
+	if err == nil && latency > 0 {
+		if p.baseline == 0 || latency < p.baseline {
+			p.baseline = latency
+		} else {
+			p.baseline = time.Duration(float64(p.baseline) * baselineDecay)
+		}
+		overloaded = float64(latency) > float64(p.baseline)*latencyTolerance
+	}
+
+	if overloaded {
+		p.healthy = 0
+		p.limit = int(float64(p.limit) * decreaseFactor)
+		if p.limit < p.min {
+			p.limit = p.min
+		}
+	} else {
+		// Additive increase: one more worker per full window of successes
+		p.healthy++
+		if p.healthy >= p.limit {
+			p.healthy = 0
+			if p.limit < p.max {
+				p.limit++
+			}
+		}
+	}
+
+	limit := p.limit
+	onResize := p.onResize
+	p.mutex.Unlock()
+
+	if limit != old {
+		p.cond.Broadcast()
+		if onResize != nil {
+			onResize(limit)
+		}
+	}
+}
+
+// Concurrency returns the current worker limit and how many are busy
+func (p *WorkerPool) Concurrency() (limit, active int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.limit, p.active
+}
+
+// Submit blocks until a worker is free and runs task on it
 func (p *WorkerPool) Submit(task func()) {
+	p.mutex.Lock()
+	for p.active >= p.limit && !p.stopped {
+		p.cond.Wait()
+	}
+	if p.stopped {
+		p.mutex.Unlock()
+		return
+	}
+	p.active++
 	p.wg.Add(1)
-	p.workers <- struct{}{}
+	p.mutex.Unlock()
 
 	go func() {
 		defer func() {
-			<-p.workers
+			p.mutex.Lock()
+			p.active--
+			p.mutex.Unlock()
+			p.cond.Signal()
 			p.wg.Done()
 		}()
 		task()
@@ -33,5 +170,8 @@ func (p *WorkerPool) Wait() {
 }
 
 func (p *WorkerPool) Stop() {
-	close(p.workers)
-}
\ No newline at end of file
+	p.mutex.Lock()
+	p.stopped = true
+	p.mutex.Unlock()
+	p.cond.Broadcast()
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolObserve(t *testing.T) {
+	const fail = time.Duration(-1)
+	repeat := func(d time.Duration, n int) []time.Duration {
+		out := make([]time.Duration, n)
+		for i := range out {
+			out[i] = d
+		}
+		return out
+	}
+	ms := time.Millisecond
+
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    int
+	}{
+		{"starts at max", nil, 8},
+		{"failure shrinks", []time.Duration{fail}, 6},
+		{"failures shrink to min", repeat(fail, 10), 2},
+		{"slow response shrinks", []time.Duration{100 * ms, 300 * ms}, 6},
+		{"somewhat slower is fine", []time.Duration{100 * ms, 150 * ms}, 8},
+		{"grows one per window", append([]time.Duration{fail, fail}, repeat(100*ms, 4)...), 5},
+		{"grows again after a full window", append([]time.Duration{fail, fail}, repeat(100*ms, 9)...), 6},
+		{"stays at max", repeat(100*ms, 50), 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewAdaptiveWorkerPool(2, 8)
+			var resized []int
+			p.OnResize(func(limit int) { resized = append(resized, limit) })
+			for _, latency := range tt.samples {
+				if latency == fail {
+					p.Observe(0, errors.New("failed"))
+				} else {
+					p.Observe(latency, nil)
+				}
+			}
+			if limit, _ := p.Concurrency(); limit != tt.want {
+				t.Errorf("limit = %d, want %d", limit, tt.want)
+			}
+			if last := resized[len(resized)-1]; last != tt.want {
+				t.Errorf("last resize reported %d, want %d", last, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolLimit(t *testing.T) {
+	p := NewAdaptiveWorkerPool(1, 3)
+	var running, peak int64
+	var mutex sync.Mutex
+	for i := 0; i < 20; i++ {
+		p.Submit(func() {
+			n := atomic.AddInt64(&running, 1)
+			mutex.Lock()
+			if n > peak {
+				peak = n
+			}
+			mutex.Unlock()
+			time.Sleep(2 * time.Millisecond)
+			atomic.AddInt64(&running, -1)
+		})
+	}
+	p.Wait()
+	p.Stop()
+	if peak > 3 {
+		t.Errorf("%d tasks ran at once, limit 3", peak)
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ContactAccess is what a bot knows about whether it may answer a contact
+type ContactAccess struct {
+	Contact   string
+	Status    string
+	UpdatedAt time.Time
+}
+
+// SetAccess records a contact's access status
+func (s *Store) SetAccess(botID, contact, status string) error {
+	_, err := s.db.Exec(`INSERT INTO contact_access (bot_id, contact, status, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (bot_id, contact) DO UPDATE SET status = excluded.status, updated_at = excluded.updated_at`,
+		botID, contact, status, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save access of %s: %v", contact, err)
+	}
+	return nil
+}
+
+// GetAccess returns a contact's access status, empty if the contact is
+// unknown
+func (s *Store) GetAccess(botID, contact string) (string, error) {
+	var status string
+	err := s.db.QueryRow(`SELECT status FROM contact_access WHERE bot_id = ? AND contact = ?`,
+		botID, contact).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read access of %s: %v", contact, err)
+	}
+	return status, nil
+}
+
+// DeleteAccess forgets a contact's access status
+func (s *Store) DeleteAccess(botID, contact string) error {
+	_, err := s.db.Exec(`DELETE FROM contact_access WHERE bot_id = ? AND contact = ?`, botID, contact)
+	return err
+}
+
+// ListAccess returns the access status of every known contact of a bot
+func (s *Store) ListAccess(botID string) ([]ContactAccess, error) {
+	rows, err := s.db.Query(`SELECT contact, status, updated_at FROM contact_access
+		WHERE bot_id = ? ORDER BY contact`, botID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ContactAccess
+	for rows.Next() {
+		var e ContactAccess
+		var updated int64
+		if err := rows.Scan(&e.Contact, &e.Status, &updated); err != nil {
+			return nil, err
+		}
+		e.UpdatedAt = time.Unix(updated, 0)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
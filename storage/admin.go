@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// AuditEntry is one admin action taken on a bot
+type AuditEntry struct {
+	ID     int64
+	BotID  string
+	Actor  string
+	Action string
+	Args   string
+	Result string
+	Time   time.Time
+}
+
+// SetPaused pauses or resumes a chat
+func (s *Store) SetPaused(botID, chat string, paused bool) error {
+	query := `INSERT OR IGNORE INTO paused_chats (bot_id, chat) VALUES (?, ?)`
+	if !paused {
+		query = `DELETE FROM paused_chats WHERE bot_id = ? AND chat = ?`
+	}
+	_, err := s.db.Exec(query, botID, chat)
+	return err
+}
+
+// ListPaused returns a bot's paused chats
+func (s *Store) ListPaused(botID string) ([]string, error) {
+	return s.listColumn(`SELECT chat FROM paused_chats WHERE bot_id = ? ORDER BY chat`, botID)
+}
+
+// SetBlocked blocks or unblocks a contact
+func (s *Store) SetBlocked(botID, contact string, blocked bool) error {
+	query := `INSERT OR IGNORE INTO blocked_contacts (bot_id, contact) VALUES (?, ?)`
+	if !blocked {
+		query = `DELETE FROM blocked_contacts WHERE bot_id = ? AND contact = ?`
+	}
+	_, err := s.db.Exec(query, botID, contact)
+	return err
+}
+
+// ListBlocked returns a bot's blocked contacts
+func (s *Store) ListBlocked(botID string) ([]string, error) {
+	return s.listColumn(`SELECT contact FROM blocked_contacts WHERE bot_id = ? ORDER BY contact`, botID)
+}
+
+// AddAudit records an admin action
+func (s *Store) AddAudit(e AuditEntry) error {
+	_, err := s.db.Exec(`INSERT INTO admin_audit (bot_id, actor, action, args, result, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		e.BotID, e.Actor, e.Action, e.Args, e.Result, e.Time.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+// ListAudit returns the latest admin actions of a bot, newest first
+func (s *Store) ListAudit(botID string, limit int) ([]AuditEntry, error) {
+	rows, err := s.db.Query(`SELECT id, bot_id, actor, action, args, result, created_at
+		FROM admin_audit WHERE bot_id = ? ORDER BY id DESC LIMIT ?`, botID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var created int64
+		if err := rows.Scan(&e.ID, &e.BotID, &e.Actor, &e.Action, &e.Args, &e.Result, &created); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(created, 0)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// listColumn runs a query returning a single text column
+func (s *Store) listColumn(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// APIRequest is a message sent through the HTTP API under an idempotency key
+type APIRequest struct {
+	Token string
+	Key   string
+	// Fingerprint identifies the request body, so a key can't be reused
+	// for a different message
+	Fingerprint string
+	BotID       string
+	// MessageID is empty while the message is being sent
+	MessageID string
+	CreatedAt time.Time
+}
+
+// ClaimAPIRequest records req unless its token already used its key since
+// the given time. It returns the earlier request and false if so; older
+// keys are forgotten.
+func (s *Store) ClaimAPIRequest(req APIRequest, since time.Time) (APIRequest, bool, error) {
+	if _, err := s.db.Exec(`DELETE FROM api_requests WHERE created_at < ?`, since.Unix()); err != nil {
+		return APIRequest{}, false, fmt.Errorf("failed to expire idempotency keys: %v", err)
+	}
+
+	res, err := s.db.Exec(`INSERT OR IGNORE INTO api_requests (token, key, fingerprint, bot_id, created_at)
+		VALUES (?, ?, ?, ?, ?)`, req.Token, req.Key, req.Fingerprint, req.BotID, req.CreatedAt.Unix())
+	if err != nil {
+		return APIRequest{}, false, fmt.Errorf("failed to save idempotency key: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return req, true, nil
+	}
+
+	prev := APIRequest{Token: req.Token, Key: req.Key}
+	var created int64
+	err = s.db.QueryRow(`SELECT fingerprint, bot_id, message_id, created_at FROM api_requests WHERE token = ? AND key = ?`,
+		req.Token, req.Key).Scan(&prev.Fingerprint, &prev.BotID, &prev.MessageID, &created)
+	if err != nil {
+		return APIRequest{}, false, fmt.Errorf("failed to load idempotency key: %v", err)
+	}
+	prev.CreatedAt = time.Unix(created, 0)
+	return prev, false, nil
+}
+
+// CompleteAPIRequest stores the ID of the message sent for a claimed key
+func (s *Store) CompleteAPIRequest(token, key, messageID string) error {
+	_, err := s.db.Exec(`UPDATE api_requests SET message_id = ? WHERE token = ? AND key = ?`, messageID, token, key)
+	return err
+}
+
+// ReleaseAPIRequest forgets a claimed key whose message wasn't sent, so the
+// request can be retried
+func (s *Store) ReleaseAPIRequest(token, key string) error {
+	_, err := s.db.Exec(`DELETE FROM api_requests WHERE token = ? AND key = ? AND message_id = ''`, token, key)
+	return err
+}
@@ -0,0 +1,32 @@
+package storage
+
+import "fmt"
+
+// BotIDs returns the bot ID given to each linked account, by its JID
+func (s *Store) BotIDs() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT jid, bot_id FROM bot_ids`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bot IDs: %v", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]string)
+	for rows.Next() {
+		var jid, botID string
+		if err := rows.Scan(&jid, &botID); err != nil {
+			return nil, err
+		}
+		ids[jid] = botID
+	}
+	return ids, rows.Err()
+}
+
+// SetBotID remembers the bot ID of a linked account
+func (s *Store) SetBotID(jid, botID string) error {
+	_, err := s.db.Exec(`INSERT INTO bot_ids (jid, bot_id) VALUES (?, ?)
+		ON CONFLICT (jid) DO UPDATE SET bot_id = excluded.bot_id`, jid, botID)
+	if err != nil {
+		return fmt.Errorf("failed to save bot ID: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Campaign statuses
+const (
+	CampaignDraft     = "draft"
+	CampaignRunning   = "running"
+	CampaignPaused    = "paused"
+	CampaignDone      = "done"
+	CampaignCancelled = "cancelled"
+)
+
+// Recipient statuses
+const (
+	RecipientQueued  = "queued"
+	RecipientSent    = "sent"
+	RecipientFailed  = "failed"
+	RecipientReplied = "replied"
+)
+
+// Campaign is a message sent by a bot to a list of contacts
+type Campaign struct {
+	ID         int64
+	BotID      string
+	Name       string
+	Template   string
+	Status     string
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Counts holds the number of recipients per status
+	Counts map[string]int
+}
+
+// Recipient is one contact of a campaign
+type Recipient struct {
+	CampaignID int64
+	Contact    string
+	// Vars are the contact's values for the message template
+	Vars      map[string]string
+	Status    string
+	Error     string
+	SentAt    time.Time
+	RepliedAt time.Time
+}
+
+// AddCampaign stores a draft campaign with its recipients and returns its
+// ID. A contact listed twice is only messaged once.
+func (s *Store) AddCampaign(c Campaign, recipients []Recipient) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO campaigns (bot_id, name, template, status, created_at) VALUES (?, ?, ?, ?, ?)`,
+		c.BotID, c.Name, c.Template, CampaignDraft, c.CreatedAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to create campaign: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range recipients {
+		vars, err := json.Marshal(r.Vars)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO campaign_recipients (campaign_id, contact, vars, status)
+			VALUES (?, ?, ?, ?)`, id, r.Contact, string(vars), RecipientQueued); err != nil {
+			return 0, fmt.Errorf("failed to add recipient %s: %v", r.Contact, err)
+		}
+	}
+	return id, tx.Commit()
+}
+
+// GetCampaign returns a campaign with its recipient counts
+func (s *Store) GetCampaign(id int64) (Campaign, error) {
+	campaigns, err := s.queryCampaigns(`WHERE id = ?`, id)
+	if err != nil {
+		return Campaign{}, err
+	}
+	if len(campaigns) == 0 {
+		return Campaign{}, fmt.Errorf("campaign %d not found", id)
+	}
+	return campaigns[0], nil
+}
+
+// ListCampaigns returns a bot's campaigns, newest first; an empty botID
+// returns those of every bot
+func (s *Store) ListCampaigns(botID string) ([]Campaign, error) {
+	if botID == "" {
+		return s.queryCampaigns(`ORDER BY id DESC`)
+	}
+	return s.queryCampaigns(`WHERE bot_id = ? ORDER BY id DESC`, botID)
+}
+
+// SetCampaignStatus changes a campaign's status if it is currently in one
+// of the from statuses, recording when it started and finished. It reports
+// whether the status changed.
+func (s *Store) SetCampaignStatus(id int64, status string, from ...string) (bool, error) {
+	if len(from) == 0 {
+		return false, fmt.Errorf("no statuses to change campaign %d from", id)
+	}
+	now := time.Now().Unix()
+	args := []interface{}{status, status, CampaignRunning, now, status, CampaignDone, CampaignCancelled, now, id}
+	for _, f := range from {
+		args = append(args, f)
+	}
+	res, err := s.db.Exec(`UPDATE campaigns SET status = ?,
+		started_at = CASE WHEN ? = ? AND started_at = 0 THEN ? ELSE started_at END,
+		finished_at = CASE WHEN ? IN (?, ?) THEN ? ELSE finished_at END
+		WHERE id = ? AND status IN (?`+strings.Repeat(", ?", len(from)-1)+`)`, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update campaign %d: %v", id, err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CampaignStatus returns a campaign's current status
+func (s *Store) CampaignStatus(id int64) (string, error) {
+	var status string
+	err := s.db.QueryRow(`SELECT status FROM campaigns WHERE id = ?`, id).Scan(&status)
+	return status, err
+}
+
+// RunningCampaigns returns the running campaigns of every bot, oldest first
+func (s *Store) RunningCampaigns() ([]Campaign, error) {
+	return s.queryCampaigns(`WHERE status = ? ORDER BY id`, CampaignRunning)
+}
+
+// NextRecipient returns the next queued recipient of a campaign, false if
+// there is none left
+func (s *Store) NextRecipient(campaignID int64) (Recipient, bool, error) {
+	recipients, err := s.queryRecipients(`WHERE campaign_id = ? AND status = ? ORDER BY rowid LIMIT 1`,
+		campaignID, RecipientQueued)
+	if err != nil || len(recipients) == 0 {
+		return Recipient{}, false, err
+	}
+	return recipients[0], true, nil
+}
+
+// ListRecipients returns a campaign's recipients, optionally only those
+// with the given status
+func (s *Store) ListRecipients(campaignID int64, status string) ([]Recipient, error) {
+	if status == "" {
+		return s.queryRecipients(`WHERE campaign_id = ? ORDER BY rowid`, campaignID)
+	}
+	return s.queryRecipients(`WHERE campaign_id = ? AND status = ? ORDER BY rowid`, campaignID, status)
+}
+
+// SetRecipientStatus records the outcome of sending to a recipient
+func (s *Store) SetRecipientStatus(campaignID int64, contact, status, errMsg string) error {
+	_, err := s.db.Exec(`UPDATE campaign_recipients SET status = ?, error = ?, sent_at = ?
+		WHERE campaign_id = ? AND contact = ?`,
+		status, errMsg, time.Now().Unix(), campaignID, contact)
+	return err
+}
+
+// MarkReplied marks a contact as having replied to the campaigns of a bot
+// it was sent within the given window
+func (s *Store) MarkReplied(botID, contact string, window time.Duration) error {
+	now := time.Now()
+	_, err := s.db.Exec(`UPDATE campaign_recipients SET status = ?, replied_at = ?
+		WHERE contact = ? AND status = ? AND sent_at >= ?
+		AND campaign_id IN (SELECT id FROM campaigns WHERE bot_id = ?)`,
+		RecipientReplied, now.Unix(), contact, RecipientSent, now.Add(-window).Unix(), botID)
+	return err
+}
+
+// ContactsWithTag returns a bot's contacts with a tag
+func (s *Store) ContactsWithTag(botID, tag string) ([]string, error) {
+	return s.listColumn(`SELECT contact FROM contact_tags WHERE bot_id = ? AND tag = ? ORDER BY contact`, botID, tag)
+}
+
+// SetTag adds or removes a tag of a contact
+func (s *Store) SetTag(botID, contact, tag string, tagged bool) error {
+	query := `INSERT OR IGNORE INTO contact_tags (bot_id, contact, tag) VALUES (?, ?, ?)`
+	if !tagged {
+		query = `DELETE FROM contact_tags WHERE bot_id = ? AND contact = ? AND tag = ?`
+	}
+	_, err := s.db.Exec(query, botID, contact, tag)
+	return err
+}
+
+func (s *Store) queryCampaigns(where string, args ...interface{}) ([]Campaign, error) {
+	rows, err := s.db.Query(`SELECT id, bot_id, name, template, status, created_at, started_at, finished_at
+		FROM campaigns `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []Campaign
+	for rows.Next() {
+		var c Campaign
+		var created, started, finished int64
+		if err := rows.Scan(&c.ID, &c.BotID, &c.Name, &c.Template, &c.Status, &created, &started, &finished); err != nil {
+			return nil, err
+		}
+		c.CreatedAt, c.StartedAt, c.FinishedAt = time.Unix(created, 0), unixTime(started), unixTime(finished)
+		campaigns = append(campaigns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range campaigns {
+		if campaigns[i].Counts, err = s.recipientCounts(campaigns[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return campaigns, nil
+}
+
+func (s *Store) recipientCounts(campaignID int64) (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM campaign_recipients WHERE campaign_id = ? GROUP BY status`,
+		campaignID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+func (s *Store) queryRecipients(where string, args ...interface{}) ([]Recipient, error) {
+	rows, err := s.db.Query(`SELECT campaign_id, contact, vars, status, error, sent_at, replied_at
+		FROM campaign_recipients `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []Recipient
+	for rows.Next() {
+		var r Recipient
+		var vars string
+		var sent, replied int64
+		if err := rows.Scan(&r.CampaignID, &r.Contact, &vars, &r.Status, &r.Error, &sent, &replied); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(vars), &r.Vars); err != nil {
+			return nil, err
+		}
+		r.SentAt, r.RepliedAt = unixTime(sent), unixTime(replied)
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// unixTime converts a stored timestamp, 0 meaning never
+func unixTime(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCampaignStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		allowed  []string
+		changed  bool
+		started  bool
+		finished bool
+	}{
+		{"start a draft", CampaignDraft, CampaignRunning, []string{CampaignDraft, CampaignPaused}, true, true, false},
+		{"pause", CampaignRunning, CampaignPaused, []string{CampaignRunning}, true, true, false},
+		{"resume", CampaignPaused, CampaignRunning, []string{CampaignDraft, CampaignPaused}, true, true, false},
+		{"finish", CampaignRunning, CampaignDone, []string{CampaignRunning}, true, true, true},
+		{"cancel", CampaignPaused, CampaignCancelled, []string{CampaignDraft, CampaignRunning, CampaignPaused}, true, true, true},
+		{"no restart once done", CampaignDone, CampaignRunning, []string{CampaignDraft, CampaignPaused}, false, true, true},
+		{"cancel a draft", CampaignDraft, CampaignCancelled, []string{CampaignDraft}, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openTestStore(t)
+			id, err := s.AddCampaign(Campaign{BotID: "1", Name: "n", Template: "t", CreatedAt: time.Now()}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Walk the campaign to the starting status
+			path := map[string][]string{
+				CampaignDraft:   nil,
+				CampaignRunning: {CampaignRunning},
+				CampaignPaused:  {CampaignRunning, CampaignPaused},
+				CampaignDone:    {CampaignRunning, CampaignDone},
+			}
+			prev := CampaignDraft
+			for _, status := range path[tt.from] {
+				if _, err := s.SetCampaignStatus(id, status, prev); err != nil {
+					t.Fatal(err)
+				}
+				prev = status
+			}
+
+			changed, err := s.SetCampaignStatus(id, tt.to, tt.allowed...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			c, err := s.GetCampaign(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.from
+			if tt.changed {
+				want = tt.to
+			}
+			if c.Status != want {
+				t.Errorf("status = %s, want %s", c.Status, want)
+			}
+			if started := !c.StartedAt.IsZero(); started != tt.started {
+				t.Errorf("started = %v, want %v", started, tt.started)
+			}
+			if finished := !c.FinishedAt.IsZero(); finished != tt.finished {
+				t.Errorf("finished = %v, want %v", finished, tt.finished)
+			}
+		})
+	}
+}
+
+func TestCampaignRecipients(t *testing.T) {
+	s := openTestStore(t)
+	recipients := []Recipient{
+		{Contact: "a", Vars: map[string]string{"name": "Ana"}},
+		{Contact: "b"},
+		{Contact: "a", Vars: map[string]string{"name": "again"}},
+	}
+	id, err := s.AddCampaign(Campaign{BotID: "1", CreatedAt: time.Now()}, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, ok, err := s.NextRecipient(id)
+	if err != nil || !ok || next.Contact != "a" || next.Vars["name"] != "Ana" {
+		t.Fatalf("NextRecipient = %+v, %v, %v, want a with its first vars", next, ok, err)
+	}
+	if err := s.SetRecipientStatus(id, "a", RecipientSent, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRecipientStatus(id, "b", RecipientFailed, "not on WhatsApp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.NextRecipient(id); ok {
+		t.Error("NextRecipient found a recipient after all were sent")
+	}
+
+	// Replies count within the window, for the bot that sent the campaign
+	if err := s.MarkReplied("2", "a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkReplied("1", "a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.GetCampaign(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{RecipientReplied: 1, RecipientFailed: 1}
+	if len(c.Counts) != len(want) || c.Counts[RecipientReplied] != 1 || c.Counts[RecipientFailed] != 1 {
+		t.Errorf("counts = %v, want %v", c.Counts, want)
+	}
+	failed, err := s.ListRecipients(id, RecipientFailed)
+	if err != nil || len(failed) != 1 || failed[0].Error != "not on WhatsApp" {
+		t.Errorf("failed recipients = %+v, %v", failed, err)
+	}
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeferredReply is a question that could not be answered when it was asked
+// and is answered once the model is reachable again
+type DeferredReply struct {
+	ID        int64
+	BotID     string
+	Chat      string
+	Sender    string
+	MessageID string
+	Prompt    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// AddDeferred stores a pending question and returns its ID
+func (s *Store) AddDeferred(d DeferredReply) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO deferred_replies
+		(bot_id, chat, sender, message_id, prompt, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		d.BotID, d.Chat, d.Sender, d.MessageID, d.Prompt, d.CreatedAt.Unix(), d.ExpiresAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to store deferred reply: %v", err)
+	}
+	return res.LastInsertId()
+}
+
+// ListDeferred returns a bot's pending questions, oldest first
+func (s *Store) ListDeferred(botID string) ([]DeferredReply, error) {
+	rows, err := s.db.Query(`SELECT id, bot_id, chat, sender, message_id, prompt, created_at, expires_at
+		FROM deferred_replies WHERE bot_id = ? ORDER BY created_at, id`, botID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []DeferredReply
+	for rows.Next() {
+		var d DeferredReply
+		var created, expires int64
+		if err := rows.Scan(&d.ID, &d.BotID, &d.Chat, &d.Sender, &d.MessageID, &d.Prompt, &created, &expires); err != nil {
+			return nil, err
+		}
+		d.CreatedAt, d.ExpiresAt = time.Unix(created, 0), time.Unix(expires, 0)
+		pending = append(pending, d)
+	}
+	return pending, rows.Err()
+}
+
+// CountChatDeferred returns how many questions of a chat are pending
+func (s *Store) CountChatDeferred(botID, chat string) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM deferred_replies WHERE bot_id = ? AND chat = ?`, botID, chat).Scan(&count)
+	return count, err
+}
+
+// DeleteChatDeferred removes all pending questions of a chat
+func (s *Store) DeleteChatDeferred(botID, chat string) error {
+	_, err := s.db.Exec(`DELETE FROM deferred_replies WHERE bot_id = ? AND chat = ?`, botID, chat)
+	return err
+}
+
+// DeleteDeferred removes a question once it was answered or abandoned
+func (s *Store) DeleteDeferred(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM deferred_replies WHERE id = ?`, id)
+	return err
+}
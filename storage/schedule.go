@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Statuses of a scheduled message
+const (
+	JobPending   = "pending"
+	JobSent      = "sent"
+	JobMissed    = "missed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// ScheduledMessage is a message to send at a later time, once or on a
+// cron schedule
+type ScheduledMessage struct {
+	ID    int64
+	BotID string
+	Chat  string
+	Text  string
+	// Kind tells reminders a contact asked for from messages an operator
+	// scheduled
+	Kind string
+	// Cron is the schedule of a recurring message, empty for one-off ones
+	Cron      string
+	NextRun   time.Time
+	LastRun   time.Time
+	Status    string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// AddScheduled stores a scheduled message and returns its ID
+func (s *Store) AddScheduled(m ScheduledMessage) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO scheduled_messages
+		(bot_id, chat, text, kind, cron, next_run, status, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.BotID, m.Chat, m.Text, m.Kind, m.Cron, m.NextRun.Unix(), JobPending, m.CreatedBy, m.CreatedAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to schedule message: %v", err)
+	}
+	return res.LastInsertId()
+}
+
+// DueScheduled returns the pending messages due at now, oldest first
+func (s *Store) DueScheduled(now time.Time) ([]ScheduledMessage, error) {
+	return s.queryScheduled(`WHERE status = ? AND next_run <= ? ORDER BY next_run, id`, JobPending, now.Unix())
+}
+
+// ListScheduled returns a bot's pending messages, optionally only those
+// of one chat
+func (s *Store) ListScheduled(botID, chat string) ([]ScheduledMessage, error) {
+	if chat == "" {
+		return s.queryScheduled(`WHERE bot_id = ? AND status = ? ORDER BY next_run, id`, botID, JobPending)
+	}
+	return s.queryScheduled(`WHERE bot_id = ? AND chat = ? AND status = ? ORDER BY next_run, id`,
+		botID, chat, JobPending)
+}
+
+// UpdateScheduled records a run of a scheduled message: its new status
+// and, for recurring ones, the next run
+func (s *Store) UpdateScheduled(id int64, status string, lastRun, nextRun time.Time) error {
+	_, err := s.db.Exec(`UPDATE scheduled_messages SET status = ?, last_run = ?, next_run = ? WHERE id = ?`,
+		status, lastRun.Unix(), nextRun.Unix(), id)
+	return err
+}
+
+// CancelScheduled cancels a pending message of a bot. chat, if set,
+// restricts it to that chat's messages. It reports whether a message was
+// cancelled.
+func (s *Store) CancelScheduled(botID, chat string, id int64) (bool, error) {
+	query := `UPDATE scheduled_messages SET status = ? WHERE id = ? AND bot_id = ? AND status = ?`
+	args := []interface{}{JobCancelled, id, botID, JobPending}
+	if chat != "" {
+		query += ` AND chat = ?`
+		args = append(args, chat)
+	}
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Store) queryScheduled(where string, args ...interface{}) ([]ScheduledMessage, error) {
+	rows, err := s.db.Query(`SELECT id, bot_id, chat, text, kind, cron, next_run, last_run, status, created_by, created_at
+		FROM scheduled_messages `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []ScheduledMessage
+	for rows.Next() {
+		var m ScheduledMessage
+		var next, last, created int64
+		if err := rows.Scan(&m.ID, &m.BotID, &m.Chat, &m.Text, &m.Kind, &m.Cron, &next, &last,
+			&m.Status, &m.CreatedBy, &created); err != nil {
+			return nil, err
+		}
+		m.NextRun, m.CreatedAt = time.Unix(next, 0), time.Unix(created, 0)
+		if last > 0 {
+			m.LastRun = time.Unix(last, 0)
+		}
+		jobs = append(jobs, m)
+	}
+	return jobs, rows.Err()
+}
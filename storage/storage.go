@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migrations create the bot's own tables next to the whatsmeow store. They
+// must be idempotent, every statement runs on each start.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS contact_usage (
+		bot_id   TEXT    NOT NULL,
+		contact  TEXT    NOT NULL,
+		period   TEXT    NOT NULL,
+		messages INTEGER NOT NULL DEFAULT 0,
+		tokens   INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (bot_id, contact, period)
+	)`,
+	`CREATE TABLE IF NOT EXISTS deferred_replies (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		bot_id     TEXT    NOT NULL,
+		chat       TEXT    NOT NULL,
+		sender     TEXT    NOT NULL,
+		message_id TEXT    NOT NULL,
+		prompt     TEXT    NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS deferred_replies_bot ON deferred_replies (bot_id, created_at)`,
+	`CREATE TABLE IF NOT EXISTS paused_chats (
+		bot_id TEXT NOT NULL,
+		chat   TEXT NOT NULL,
+		PRIMARY KEY (bot_id, chat)
+	)`,
+	`CREATE TABLE IF NOT EXISTS blocked_contacts (
+		bot_id  TEXT NOT NULL,
+		contact TEXT NOT NULL,
+		PRIMARY KEY (bot_id, contact)
+	)`,
+	`CREATE TABLE IF NOT EXISTS contact_access (
+		bot_id     TEXT    NOT NULL,
+		contact    TEXT    NOT NULL,
+		status     TEXT    NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (bot_id, contact)
+	)`,
+	`CREATE TABLE IF NOT EXISTS human_mode (
+		bot_id TEXT    NOT NULL,
+		chat   TEXT    NOT NULL,
+		until  INTEGER NOT NULL,
+		PRIMARY KEY (bot_id, chat)
+	)`,
+	`CREATE TABLE IF NOT EXISTS admin_audit (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		bot_id     TEXT    NOT NULL,
+		actor      TEXT    NOT NULL,
+		action     TEXT    NOT NULL,
+		args       TEXT    NOT NULL,
+		result     TEXT    NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS scheduled_messages (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		bot_id     TEXT    NOT NULL,
+		chat       TEXT    NOT NULL,
+		text       TEXT    NOT NULL,
+		kind       TEXT    NOT NULL,
+		cron       TEXT    NOT NULL DEFAULT '',
+		next_run   INTEGER NOT NULL,
+		last_run   INTEGER NOT NULL DEFAULT 0,
+		status     TEXT    NOT NULL,
+		created_by TEXT    NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS scheduled_messages_due ON scheduled_messages (status, next_run)`,
+	`CREATE TABLE IF NOT EXISTS contact_tags (
+		bot_id  TEXT NOT NULL,
+		contact TEXT NOT NULL,
+		tag     TEXT NOT NULL,
+		PRIMARY KEY (bot_id, contact, tag)
+	)`,
+	`CREATE TABLE IF NOT EXISTS campaigns (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		bot_id      TEXT    NOT NULL,
+		name        TEXT    NOT NULL,
+		template    TEXT    NOT NULL,
+		status      TEXT    NOT NULL,
+		created_at  INTEGER NOT NULL,
+		started_at  INTEGER NOT NULL DEFAULT 0,
+		finished_at INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS campaign_recipients (
+		campaign_id INTEGER NOT NULL,
+		contact     TEXT    NOT NULL,
+		vars        TEXT    NOT NULL,
+		status      TEXT    NOT NULL,
+		error       TEXT    NOT NULL DEFAULT '',
+		sent_at     INTEGER NOT NULL DEFAULT 0,
+		replied_at  INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (campaign_id, contact)
+	)`,
+	`CREATE INDEX IF NOT EXISTS campaign_recipients_contact ON campaign_recipients (contact, status)`,
+	`CREATE TABLE IF NOT EXISTS api_requests (
+		token       TEXT    NOT NULL,
+		key         TEXT    NOT NULL,
+		fingerprint TEXT    NOT NULL,
+		bot_id      TEXT    NOT NULL,
+		message_id  TEXT    NOT NULL DEFAULT '',
+		created_at  INTEGER NOT NULL,
+		PRIMARY KEY (token, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS bot_ids (
+		jid    TEXT PRIMARY KEY,
+		bot_id TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS timeout_stats (
+		key        TEXT    PRIMARY KEY,
+		state      TEXT    NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`,
+}
+
+// Store persists bot data (usage, schedules, ...) in SQLite
+type Store struct {
+	db *sql.DB
+}
+
+// sqlitePragmas are set on every connection unless the path sets them.
+// The whatsmeow store writes to the same file, so a writer waits for the
+// other's lock instead of failing with SQLITE_BUSY.
+var sqlitePragmas = []string{"busy_timeout(5000)", "journal_mode(WAL)"}
+
+// Open connects to the database and applies the migrations
+func Open(dbPath string) (*Store, error) {
+	db, err := sql.Open("sqlite", withPragmas(dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	for _, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate database: %v", err)
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// withPragmas adds the sqlitePragmas the path doesn't set yet
+func withPragmas(dbPath string) string {
+	for _, pragma := range sqlitePragmas {
+		name := pragma[:strings.Index(pragma, "(")]
+		if strings.Contains(dbPath, "_pragma="+name+"(") {
+			continue
+		}
+		if !strings.HasPrefix(dbPath, "file:") {
+			dbPath = "file:" + dbPath
+		}
+		sep := "&"
+		if !strings.Contains(dbPath, "?") {
+			sep = "?"
+		}
+		dbPath += sep + "_pragma=" + pragma
+	}
+	return dbPath
+}
+
+// Close closes the database connection
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// openTestStore opens a migrated database in a temporary directory
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestWithPragmas(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"bot.db", "file:bot.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"},
+		{"file:bot.db?cache=shared", "file:bot.db?cache=shared&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"},
+		{"file:bot.db?_pragma=journal_mode(DELETE)", "file:bot.db?_pragma=journal_mode(DELETE)&_pragma=busy_timeout(5000)"},
+		{
+			"file:bot.db?_pragma=busy_timeout(100)&_pragma=journal_mode(WAL)",
+			"file:bot.db?_pragma=busy_timeout(100)&_pragma=journal_mode(WAL)",
+		},
+	}
+	for _, tt := range tests {
+		if got := withPragmas(tt.path); got != tt.want {
+			t.Errorf("withPragmas(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOpenPragmas(t *testing.T) {
+	s := openTestStore(t)
+	var mode string
+	var timeout int
+	if err := s.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.QueryRow(`PRAGMA busy_timeout`).Scan(&timeout); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" || timeout != 5000 {
+		t.Errorf("journal_mode = %s, busy_timeout = %d, want wal and 5000", mode, timeout)
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetHumanMode hands a chat over to a human operator until the given time;
+// a zero time means until the bot is switched back on
+func (s *Store) SetHumanMode(botID, chat string, until time.Time) error {
+	var unix int64
+	if !until.IsZero() {
+		unix = until.Unix()
+	}
+	_, err := s.db.Exec(`INSERT INTO human_mode (bot_id, chat, until) VALUES (?, ?, ?)
+		ON CONFLICT (bot_id, chat) DO UPDATE SET until = excluded.until`,
+		botID, chat, unix)
+	if err != nil {
+		return fmt.Errorf("failed to save human mode: %v", err)
+	}
+	return nil
+}
+
+// ClearHumanMode gives a chat back to the bot
+func (s *Store) ClearHumanMode(botID, chat string) error {
+	_, err := s.db.Exec(`DELETE FROM human_mode WHERE bot_id = ? AND chat = ?`, botID, chat)
+	return err
+}
+
+// ListHumanMode returns the chats of a bot handled by a human and until
+// when, dropping those that have expired
+func (s *Store) ListHumanMode(botID string, now time.Time) (map[string]time.Time, error) {
+	if _, err := s.db.Exec(`DELETE FROM human_mode WHERE bot_id = ? AND until > 0 AND until <= ?`,
+		botID, now.Unix()); err != nil {
+		return nil, fmt.Errorf("failed to expire human mode: %v", err)
+	}
+
+	rows, err := s.db.Query(`SELECT chat, until FROM human_mode WHERE bot_id = ?`, botID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load human mode: %v", err)
+	}
+	defer rows.Close()
+
+	chats := make(map[string]time.Time)
+	for rows.Next() {
+		var chat string
+		var until int64
+		if err := rows.Scan(&chat, &until); err != nil {
+			return nil, err
+		}
+		if until > 0 {
+			chats[chat] = time.Unix(until, 0)
+		} else {
+			chats[chat] = time.Time{}
+		}
+	}
+	return chats, rows.Err()
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// SaveTimeoutStates stores the latency statistics of each request key so
+// timeouts start from what was learned before a restart
+func (s *Store) SaveTimeoutStates(states map[string][]byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	for key, state := range states {
+		_, err := tx.Exec(`INSERT INTO timeout_stats (key, state, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET state = excluded.state, updated_at = excluded.updated_at`,
+			key, string(state), now)
+		if err != nil {
+			return fmt.Errorf("failed to save timeout stats: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadTimeoutStates returns the stored latency statistics by key
+func (s *Store) LoadTimeoutStates() (map[string][]byte, error) {
+	rows, err := s.db.Query(`SELECT key, state FROM timeout_stats`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string][]byte)
+	for rows.Next() {
+		var key, state string
+		if err := rows.Scan(&key, &state); err != nil {
+			return nil, err
+		}
+		states[key] = []byte(state)
+	}
+	return states, rows.Err()
+}
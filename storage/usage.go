@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Usage is how much a contact has used a bot in the current day and month
+type Usage struct {
+	Contact         string
+	DailyMessages   int
+	DailyTokens     int
+	MonthlyMessages int
+	MonthlyTokens   int
+}
+
+func dayPeriod(t time.Time) string {
+	return "day:" + t.Format("2006-01-02")
+}
+
+func monthPeriod(t time.Time) string {
+	return "month:" + t.Format("2006-01")
+}
+
+// AddUsage records messages and tokens for a contact in the day and month
+// containing now
+func (s *Store) AddUsage(botID, contact string, now time.Time, messages, tokens int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, period := range []string{dayPeriod(now), monthPeriod(now)} {
+		_, err := tx.Exec(`INSERT INTO contact_usage (bot_id, contact, period, messages, tokens)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (bot_id, contact, period) DO UPDATE SET
+				messages = messages + excluded.messages,
+				tokens = tokens + excluded.tokens`,
+			botID, contact, period, messages, tokens)
+		if err != nil {
+			return fmt.Errorf("failed to record usage: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetUsage returns a contact's usage for the day and month containing now
+func (s *Store) GetUsage(botID, contact string, now time.Time) (Usage, error) {
+	usage := Usage{Contact: contact}
+	rows, err := s.db.Query(`SELECT period, messages, tokens FROM contact_usage
+		WHERE bot_id = ? AND contact = ? AND period IN (?, ?)`,
+		botID, contact, dayPeriod(now), monthPeriod(now))
+	if err != nil {
+		return usage, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var period string
+		var messages, tokens int
+		if err := rows.Scan(&period, &messages, &tokens); err != nil {
+			return usage, err
+		}
+		if strings.HasPrefix(period, "day:") {
+			usage.DailyMessages, usage.DailyTokens = messages, tokens
+		} else {
+			usage.MonthlyMessages, usage.MonthlyTokens = messages, tokens
+		}
+	}
+	return usage, rows.Err()
+}
+
+// ListUsage returns the usage of every contact of a bot that has used it
+// this month, busiest first
+func (s *Store) ListUsage(botID string, now time.Time) ([]Usage, error) {
+	rows, err := s.db.Query(`SELECT contact FROM contact_usage
+		WHERE bot_id = ? AND period = ? ORDER BY messages DESC`,
+		botID, monthPeriod(now))
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []string
+	for rows.Next() {
+		var contact string
+		if err := rows.Scan(&contact); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	usages := make([]Usage, 0, len(contacts))
+	for _, contact := range contacts {
+		usage, err := s.GetUsage(botID, contact, now)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// ResetUsage clears all recorded usage of a contact
+func (s *Store) ResetUsage(botID, contact string) error {
+	_, err := s.db.Exec(`DELETE FROM contact_usage WHERE bot_id = ? AND contact = ?`, botID, contact)
+	return err
+}
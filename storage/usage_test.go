@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsagePeriods(t *testing.T) {
+	s := openTestStore(t)
+	day1 := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	day2 := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	day3 := time.Date(2025, 2, 2, 12, 0, 0, 0, time.UTC)
+
+	adds := []struct {
+		bot, contact     string
+		at               time.Time
+		messages, tokens int
+	}{
+		{"1", "a", day1, 1, 100},
+		{"1", "a", day2, 1, 10},
+		{"1", "a", day2, 2, 20},
+		{"1", "a", day3, 1, 5},
+		{"1", "b", day3, 1, 1},
+		{"2", "a", day3, 7, 70},
+	}
+	for _, a := range adds {
+		if err := s.AddUsage(a.bot, a.contact, a.at, a.messages, a.tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		bot, contact string
+		at           time.Time
+		want         Usage
+	}{
+		{"previous month", "1", "a", day1, Usage{Contact: "a", DailyMessages: 1, DailyTokens: 100, MonthlyMessages: 1, MonthlyTokens: 100}},
+		{"day within month", "1", "a", day2, Usage{Contact: "a", DailyMessages: 3, DailyTokens: 30, MonthlyMessages: 4, MonthlyTokens: 35}},
+		{"next day", "1", "a", day3, Usage{Contact: "a", DailyMessages: 1, DailyTokens: 5, MonthlyMessages: 4, MonthlyTokens: 35}},
+		{"other bot", "2", "a", day3, Usage{Contact: "a", DailyMessages: 7, DailyTokens: 70, MonthlyMessages: 7, MonthlyTokens: 70}},
+		{"unknown contact", "1", "c", day3, Usage{Contact: "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetUsage(tt.bot, tt.contact, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("GetUsage = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	list, err := s.ListUsage("1", day3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Contact != "a" || list[1].Contact != "b" {
+		t.Errorf("ListUsage = %+v, want a then b", list)
+	}
+
+	if err := s.ResetUsage("1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetUsage("1", "a", day3); got != (Usage{Contact: "a"}) {
+		t.Errorf("usage after reset = %+v", got)
+	}
+	if got, _ := s.GetUsage("2", "a", day3); got.MonthlyMessages != 7 {
+		t.Errorf("reset cleared another bot's usage: %+v", got)
+	}
+}
@@ -9,6 +9,7 @@ type Message struct {
 	Content   interface{}
 	Timestamp time.Time
 	ChatID    string
+	Priority  Priority
 }
 
 // MessageType defines the type of a message
@@ -22,3 +23,34 @@ const (
 	// DocumentMessage is a document message
 	DocumentMessage MessageType = "document"
 )
+
+// Priority defines the lane a message is scheduled in
+type Priority int
+
+const (
+	// PriorityNormal is used for everyone else, and is the zero value
+	PriorityNormal Priority = iota
+	// PriorityOwner is used for the bot owner's and admins' chats
+	PriorityOwner
+	// PriorityVIP is used for allowlisted contacts
+	PriorityVIP
+	// PriorityBulk is used for broadcast and bulk traffic
+	PriorityBulk
+)
+
+// Priorities lists all lanes from most to least important
+var Priorities = []Priority{PriorityOwner, PriorityVIP, PriorityNormal, PriorityBulk}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityOwner:
+		return "owner"
+	case PriorityVIP:
+		return "vip"
+	case PriorityNormal:
+		return "normal"
+	case PriorityBulk:
+		return "bulk"
+	}
+	return "unknown"
+}
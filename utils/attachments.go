@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// Attachment is part of a reply that is sent as a file instead of text
+type Attachment struct {
+	FileName string
+	MimeType string
+	Data     []byte
+	// Inline is the original text, sent instead if the upload fails
+	Inline string
+}
+
+// codeExtensions maps code block languages to file extensions
+var codeExtensions = map[string]string{
+	"go": "go", "python": "py", "py": "py", "javascript": "js", "js": "js",
+	"typescript": "ts", "ts": "ts", "java": "java", "c": "c", "cpp": "cpp",
+	"c++": "cpp", "csharp": "cs", "cs": "cs", "rust": "rs", "ruby": "rb",
+	"php": "php", "kotlin": "kt", "swift": "swift", "sh": "sh", "bash": "sh",
+	"shell": "sh", "sql": "sql", "html": "html", "css": "css", "json": "json",
+	"yaml": "yaml", "yml": "yaml", "xml": "xml", "csv": "csv", "markdown": "md",
+}
+
+// ExtractAttachments moves oversized code blocks and tables out of a
+// Markdown reply. A code block of more than codeLines lines becomes a
+// snippet file named after its language, a table of more than tableRows
+// rows or tableColumns columns becomes a CSV file. Each is replaced by a
+// one-line note in the text. A zero threshold disables that check.
+func ExtractAttachments(text string, codeLines, tableRows, tableColumns int) (string, []Attachment) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var out []string
+	var files []Attachment
+
+	// Files are numbered per name, snippet.go, snippet-2.go, table.csv
+	used := make(map[string]int)
+	name := func(base, ext string) string {
+		used[base+"."+ext]++
+		if n := used[base+"."+ext]; n > 1 {
+			return fmt.Sprintf("%s-%d.%s", base, n, ext)
+		}
+		return base + "." + ext
+	}
+
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+
+		if strings.HasPrefix(trimmed, "```") {
+			start := i
+			var body []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				body = append(body, lines[i])
+			}
+			end := i
+			if end >= len(lines) {
+				end = len(lines) - 1
+			}
+			block := lines[start : end+1]
+
+			if codeLines <= 0 || len(body) <= codeLines {
+				out = append(out, block...)
+				continue
+			}
+			ext, exists := codeExtensions[strings.ToLower(strings.TrimPrefix(trimmed, "```"))]
+			if !exists {
+				ext = "txt"
+			}
+			mime := "text/plain"
+			if ext == "csv" {
+				mime = "text/csv"
+			}
+			file := Attachment{
+				FileName: name("snippet", ext),
+				MimeType: mime,
+				Data:     []byte(strings.Join(body, "\n") + "\n"),
+				Inline:   strings.Join(block, "\n"),
+			}
+			files = append(files, file)
+			out = append(out, fmt.Sprintf("📎 %s (%d lines)", file.FileName, len(body)))
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && tableSepRe.MatchString(lines[i+1]) {
+			start := i
+			rows := [][]string{splitTableRow(lines[i])}
+			for i += 2; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, splitTableRow(lines[i]))
+			}
+			i--
+			block := lines[start : i+1]
+
+			tooLong := tableRows > 0 && len(rows)-1 > tableRows
+			tooWide := tableColumns > 0 && len(rows[0]) > tableColumns
+			if !tooLong && !tooWide {
+				out = append(out, block...)
+				continue
+			}
+			var buf bytes.Buffer
+			w := csv.NewWriter(&buf)
+			w.WriteAll(rows)
+			file := Attachment{
+				FileName: name("table", "csv"),
+				MimeType: "text/csv",
+				Data:     buf.Bytes(),
+				Inline:   strings.Join(block, "\n"),
+			}
+			files = append(files, file)
+			out = append(out, fmt.Sprintf("📎 %s (%d rows)", file.FileName, len(rows)-1))
+			continue
+		}
+
+		out = append(out, lines[i])
+	}
+	return strings.Join(out, "\n"), files
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractAttachments(t *testing.T) {
+	code := func(lang string, n int) string {
+		return "```" + lang + "\n" + strings.Repeat("x\n", n) + "```"
+	}
+	table := "| a | b | c |\n|---|---|---|\n| 1 | 2 | 3 |\n| 4 | 5 | 6 |"
+
+	tests := []struct {
+		name                               string
+		text                               string
+		codeLines, tableRows, tableColumns int
+		want                               string
+		files                              []string
+	}{
+		{"short code stays", code("go", 3), 5, 0, 0, code("go", 3), nil},
+		{"long code", code("go", 6), 5, 0, 0, "📎 snippet.go (6 lines)", []string{"snippet.go"}},
+		{"unknown language", code("brainfuck", 6), 5, 0, 0, "📎 snippet.txt (6 lines)", []string{"snippet.txt"}},
+		{"disabled", code("go", 60), 0, 0, 0, code("go", 60), nil},
+		{
+			"numbered per name",
+			code("go", 6) + "\n" + code("py", 6) + "\n" + code("go", 6),
+			5, 0, 0,
+			"📎 snippet.go (6 lines)\n📎 snippet.py (6 lines)\n📎 snippet-2.go (6 lines)",
+			[]string{"snippet.go", "snippet.py", "snippet-2.go"},
+		},
+		{"small table stays", table, 0, 2, 3, table, nil},
+		{"long table", table, 0, 1, 0, "📎 table.csv (2 rows)", []string{"table.csv"}},
+		{"wide table", "before\n" + table + "\nafter", 0, 0, 2, "before\n📎 table.csv (2 rows)\nafter", []string{"table.csv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, files := ExtractAttachments(tt.text, tt.codeLines, tt.tableRows, tt.tableColumns)
+			if got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+			if len(files) != len(tt.files) {
+				t.Fatalf("got %d files, want %d", len(files), len(tt.files))
+			}
+			for i, f := range files {
+				if f.FileName != tt.files[i] {
+					t.Errorf("file %d is %s, want %s", i, f.FileName, tt.files[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractAttachmentsContent(t *testing.T) {
+	_, files := ExtractAttachments("| a | b |\n|---|---|\n| 1 | x, y |", 0, 0, 1)
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+	if got, want := string(files[0].Data), "a,b\n1,\"x, y\"\n"; got != want {
+		t.Errorf("csv = %q, want %q", got, want)
+	}
+	if files[0].MimeType != "text/csv" {
+		t.Errorf("mime type = %s, want text/csv", files[0].MimeType)
+	}
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrCircuitOpen is returned while a breaker rejects requests
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens
+	// the breaker
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before probing
+	OpenTimeout time.Duration
+	// HalfOpenProbes is how many probe requests must succeed to close the
+	// breaker again
+	HalfOpenProbes int
+}
+
+// CircuitBreaker stops calls to a failing dependency. After
+// FailureThreshold consecutive failures it opens and rejects every call for
+// OpenTimeout, then lets HalfOpenProbes calls through one at a time; if they
+// all succeed it closes, if one fails it opens again.
+type CircuitBreaker struct {
+	name      string
+	config    BreakerConfig
+	mutex     sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	probing   bool
+	openedAt  time.Time
+}
+
+// BreakerStatus is a point-in-time view of a breaker for the dashboard
+type BreakerStatus struct {
+	Name     string
+	State    string
+	Failures int
+	OpenedAt time.Time
+}
+
+var (
+	breakers   = make(map[string]*CircuitBreaker)
+	breakerMux sync.RWMutex
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "State of each circuit breaker (0 closed, 1 half-open, 2 open)",
+	}, []string{"name"})
+	breakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "circuit_breaker_rejections_total",
+		Help: "Total number of calls rejected by an open circuit breaker",
+	}, []string{"name"})
+)
+
+// NewCircuitBreaker creates a breaker and registers it for the dashboard
+func NewCircuitBreaker(name string, config BreakerConfig) *CircuitBreaker {
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenProbes < 1 {
+		config.HalfOpenProbes = 1
+	}
+
+	cb := &CircuitBreaker{name: name, config: config}
+	breakerState.WithLabelValues(name).Set(float64(BreakerClosed))
+
+	breakerMux.Lock()
+	breakers[name] = cb
+	breakerMux.Unlock()
+	return cb
+}
+
+// Allow reports whether a call may go ahead. An allowed call must report
+// its outcome through the returned function.
+func (cb *CircuitBreaker) Allow() (func(err error), error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	probe := false
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.config.OpenTimeout {
+			breakerRejections.WithLabelValues(cb.name).Inc()
+			return nil, ErrCircuitOpen
+		}
+		cb.setState(BreakerHalfOpen)
+		cb.successes = 0
+		fallthrough
+	case BreakerHalfOpen:
+		// Probes go through one at a time
+		if cb.probing {
+			breakerRejections.WithLabelValues(cb.name).Inc()
+			return nil, ErrCircuitOpen
+		}
+		cb.probing = true
+		probe = true
+	}
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { cb.record(err, probe) })
+	}, nil
+}
+
+// record applies the outcome of a call. Cancellation by the caller says
+// nothing about the dependency and leaves the state unchanged.
+func (cb *CircuitBreaker) record(err error, probe bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if probe {
+		cb.probing = false
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if err != nil {
+		cb.failures++
+		cb.successes = 0
+		if probe || (cb.state == BreakerClosed && cb.failures >= cb.config.FailureThreshold) {
+			cb.openedAt = time.Now()
+			cb.setState(BreakerOpen)
+		}
+		return
+	}
+
+	cb.failures = 0
+	if probe && cb.state == BreakerHalfOpen {
+		cb.successes++
+		if cb.successes >= cb.config.HalfOpenProbes {
+			cb.setState(BreakerClosed)
+		}
+	}
+}
+
+// State returns the breaker's current state
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= cb.config.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return cb.state
+}
+
+// Status returns the breaker's state for display
+func (cb *CircuitBreaker) Status() BreakerStatus {
+	state := cb.State()
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return BreakerStatus{
+		Name:     cb.name,
+		State:    state.String(),
+		Failures: cb.failures,
+		OpenedAt: cb.openedAt,
+	}
+}
+
+// setState changes the state and updates the metric. Callers must hold mutex.
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	cb.state = state
+	breakerState.WithLabelValues(cb.name).Set(float64(state))
+}
+
+// GetBreakerStatuses returns the status of every registered breaker
+func GetBreakerStatuses() []BreakerStatus {
+	breakerMux.RLock()
+	defer breakerMux.RUnlock()
+
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, cb := range breakers {
+		statuses = append(statuses, cb.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
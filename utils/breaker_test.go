@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	errFailed := errors.New("failed")
+	// Each step is the outcome of one call, or wait to let the breaker
+	// probe again; rejected means Allow refused the call
+	const (
+		ok       = "ok"
+		fail     = "fail"
+		cancel   = "cancel"
+		wait     = "wait"
+		rejected = "rejected"
+	)
+	outcomes := map[string]error{ok: nil, fail: errFailed, cancel: context.Canceled}
+
+	tests := []struct {
+		name  string
+		steps []string
+		want  BreakerState
+	}{
+		{"closed below threshold", []string{fail, fail}, BreakerClosed},
+		{"opens at threshold", []string{fail, fail, fail}, BreakerOpen},
+		{"success resets failures", []string{fail, fail, ok, fail, fail}, BreakerClosed},
+		{"cancel is ignored", []string{fail, fail, cancel, cancel}, BreakerClosed},
+		{"rejects while open", []string{fail, fail, fail, rejected}, BreakerOpen},
+		{"half-open after timeout", []string{fail, fail, fail, wait, ok}, BreakerHalfOpen},
+		{"closes after probes", []string{fail, fail, fail, wait, ok, ok}, BreakerClosed},
+		{"failed probe reopens", []string{fail, fail, fail, wait, ok, fail}, BreakerOpen},
+		{"cancelled probe stays half-open", []string{fail, fail, fail, wait, cancel, ok}, BreakerHalfOpen},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewCircuitBreaker(fmt.Sprintf("test-%d", i), BreakerConfig{
+				FailureThreshold: 3,
+				OpenTimeout:      10 * time.Millisecond,
+				HalfOpenProbes:   2,
+			})
+			for s, step := range tt.steps {
+				if step == wait {
+					time.Sleep(20 * time.Millisecond)
+					continue
+				}
+				done, err := cb.Allow()
+				if step == rejected {
+					if !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step %d: Allow = %v, want ErrCircuitOpen", s, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("step %d: Allow: %v", s, err)
+				}
+				done(outcomes[step])
+			}
+			if got := cb.State(); got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerOneProbeAtATime(t *testing.T) {
+	cb := NewCircuitBreaker("test-probe", BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Millisecond})
+	done, _ := cb.Allow()
+	done(errors.New("failed"))
+	time.Sleep(5 * time.Millisecond)
+
+	probe, err := cb.Allow()
+	if err != nil {
+		t.Fatalf("first probe: %v", err)
+	}
+	if _, err := cb.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second probe: Allow = %v, want ErrCircuitOpen", err)
+	}
+	probe(nil)
+	if got := cb.State(); got != BreakerClosed {
+		t.Errorf("state = %s, want closed", got)
+	}
+}
@@ -0,0 +1,330 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// tableMonoWidth is the widest table that is still rendered as a grid; on
+// a phone a wider monospace block wraps and becomes unreadable
+const tableMonoWidth = 36
+
+// partPrefixRoom is reserved in each part for the "(i/n)" numbering
+const partPrefixRoom = 10
+
+var (
+	headingRe    = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+	boldItalicRe = regexp.MustCompile(`\*\*\*(\S(?:.*?\S)?)\*\*\*`)
+	boldRe       = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*`)
+	boldUnderRe  = regexp.MustCompile(`(^|\W)__(\S(?:.*?\S)?)__(\W|$)`)
+	identifierRe = regexp.MustCompile(`^\w+$`)
+	italicStarRe = regexp.MustCompile(`(^|[^*\w])\*(\S(?:[^*]*?\S)?)\*([^*\w]|$)`)
+	strikeRe     = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	linkRe       = regexp.MustCompile(`\[([^\]]+)\]\((\S+?)\)`)
+	bulletRe     = regexp.MustCompile(`^(\s*)[*+]\s+`)
+	ruleRe       = regexp.MustCompile(`^\s*(-\s*){3,}$|^\s*(\*\s*){3,}$|^\s*(_\s*){3,}$`)
+	tableSepRe   = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+// boldMark stands in for a converted bold marker while italics are
+// converted, so "**x**" doesn't turn into italics
+const boldMark = "\x00"
+
+// FormatWhatsApp converts Markdown as produced by chat models into
+// WhatsApp's formatting: *bold*, _italic_, ~strike~ and ``` monospace
+// blocks. Headings become bold lines and tables are rendered as aligned
+// monospace grids, or as one block per row when too wide for a phone.
+func FormatWhatsApp(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var out []string
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		// Code blocks are kept verbatim, without the language tag
+		if strings.HasPrefix(trimmed, "```") {
+			out = append(out, "```")
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				out = append(out, lines[i])
+			}
+			out = append(out, "```")
+			continue
+		}
+
+		// A table is a header row followed by a separator row
+		if strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && tableSepRe.MatchString(lines[i+1]) {
+			rows := [][]string{splitTableRow(line)}
+			for i += 2; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, splitTableRow(lines[i]))
+			}
+			i--
+			out = append(out, renderTable(rows))
+			continue
+		}
+
+		if ruleRe.MatchString(line) {
+			out = append(out, "")
+			continue
+		}
+		if m := headingRe.FindStringSubmatch(trimmed); m != nil {
+			out = append(out, "*"+stripEmphasis(m[1])+"*")
+			continue
+		}
+		out = append(out, formatInline(line))
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// formatInline converts the emphasis and links of one line of text
+func formatInline(line string) string {
+	line = bulletRe.ReplaceAllString(line, "${1}- ")
+	line = linkRe.ReplaceAllString(line, "$1 ($2)")
+	line = boldItalicRe.ReplaceAllString(line, boldMark+"_${1}_"+boldMark)
+	line = boldRe.ReplaceAllString(line, boldMark+"$1"+boldMark)
+	line = replaceUnderscoreBold(line, func(inner string) string { return boldMark + inner + boldMark })
+	// Adjacent italics share a separator, so one pass may miss every other
+	for prev := ""; prev != line; {
+		prev = line
+		line = italicStarRe.ReplaceAllString(line, "${1}_${2}_${3}")
+	}
+	line = strikeRe.ReplaceAllString(line, "~$1~")
+	return strings.ReplaceAll(line, boldMark, "*")
+}
+
+// stripEmphasis removes Markdown emphasis, used where the whole text is
+// already bold
+func stripEmphasis(text string) string {
+	text = boldRe.ReplaceAllString(text, "$1")
+	text = replaceUnderscoreBold(text, func(inner string) string { return inner })
+	return strings.NewReplacer("*", "", "_", "").Replace(text)
+}
+
+// replaceUnderscoreBold rewrites __bold__ text with repl. The underscores
+// must stand apart from the surrounding words, and a lone identifier like
+// __init__ is a Python name rather than emphasis.
+func replaceUnderscoreBold(text string, repl func(inner string) string) string {
+	return boldUnderRe.ReplaceAllStringFunc(text, func(match string) string {
+		m := boldUnderRe.FindStringSubmatch(match)
+		if identifierRe.MatchString(m[2]) {
+			return match
+		}
+		return m[1] + repl(m[2]) + m[3]
+	})
+}
+
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = stripEmphasis(strings.TrimSpace(cell))
+	}
+	return cells
+}
+
+// renderTable lays rows out as an aligned grid if it fits on a phone,
+// otherwise as one "header: value" block per row
+func renderTable(rows [][]string) string {
+	header := rows[0]
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for c := 0; c < len(row) && c < len(widths); c++ {
+			if w := utf8.RuneCountInString(row[c]); w > widths[c] {
+				widths[c] = w
+			}
+		}
+	}
+	total := 0
+	for _, w := range widths {
+		total += w + 2
+	}
+
+	var sb strings.Builder
+	if total-2 <= tableMonoWidth {
+		sb.WriteString("```\n")
+		for r, row := range rows {
+			for c := range widths {
+				cell := ""
+				if c < len(row) {
+					cell = row[c]
+				}
+				sb.WriteString(cell)
+				if c < len(widths)-1 {
+					sb.WriteString(strings.Repeat(" ", widths[c]-utf8.RuneCountInString(cell)+2))
+				}
+			}
+			sb.WriteString("\n")
+			if r == 0 {
+				sb.WriteString(strings.Repeat("-", total-2) + "\n")
+			}
+		}
+		sb.WriteString("```")
+		return sb.String()
+	}
+
+	for r, row := range rows[1:] {
+		if r > 0 {
+			sb.WriteString("\n\n")
+		}
+		for c, cell := range row {
+			if c >= len(header) {
+				break
+			}
+			if c > 0 {
+				sb.WriteString("\n")
+			}
+			if c == 0 {
+				fmt.Fprintf(&sb, "*%s*: %s", header[c], cell)
+			} else {
+				fmt.Fprintf(&sb, "%s: %s", header[c], cell)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// SplitMessage breaks text into parts of at most limit characters,
+// numbered "(1/3)" and so on. It splits between paragraphs and code blocks
+// where it can, then between lines, then between words; a code block that
+// is split is closed and reopened so every part renders correctly.
+func SplitMessage(text string, limit int) []string {
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+	max := limit - partPrefixRoom
+	if max < 1 {
+		max = limit
+	}
+
+	var chunks []string
+	for _, block := range splitBlocks(text) {
+		if utf8.RuneCountInString(block) <= max {
+			chunks = append(chunks, block)
+		} else if strings.HasPrefix(block, "```") {
+			chunks = append(chunks, splitCodeBlock(block, max)...)
+		} else {
+			chunks = append(chunks, splitLines(block, max)...)
+		}
+	}
+
+	// Pack as many chunks into each part as fit
+	var parts []string
+	current := ""
+	for _, chunk := range chunks {
+		if current == "" {
+			current = chunk
+		} else if utf8.RuneCountInString(current)+2+utf8.RuneCountInString(chunk) <= max {
+			current += "\n\n" + chunk
+		} else {
+			parts = append(parts, current)
+			current = chunk
+		}
+	}
+	if current != "" {
+		parts = append(parts, current)
+	}
+
+	if len(parts) > 1 {
+		for i := range parts {
+			parts[i] = fmt.Sprintf("(%d/%d)\n%s", i+1, len(parts), parts[i])
+		}
+	}
+	return parts
+}
+
+// splitBlocks splits text into paragraphs and whole code blocks
+func splitBlocks(text string) []string {
+	var blocks []string
+	var current []string
+	inCode := false
+
+	flush := func() {
+		if block := strings.Trim(strings.Join(current, "\n"), "\n"); block != "" {
+			blocks = append(blocks, block)
+		}
+		current = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		isFence := strings.HasPrefix(strings.TrimSpace(line), "```")
+		switch {
+		case isFence && !inCode:
+			flush()
+			current = append(current, line)
+			inCode = true
+		case isFence && inCode:
+			current = append(current, line)
+			flush()
+			inCode = false
+		case !inCode && strings.TrimSpace(line) == "":
+			flush()
+		default:
+			current = append(current, line)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// splitCodeBlock splits an oversized code block between lines, fencing
+// each piece
+func splitCodeBlock(block string, max int) []string {
+	lines := strings.Split(block, "\n")
+	body := lines[1:]
+	if n := len(body); n > 0 && strings.HasPrefix(strings.TrimSpace(body[n-1]), "```") {
+		body = body[:n-1]
+	}
+
+	const fences = len("```\n") + len("\n```")
+	var pieces []string
+	for _, chunk := range splitLines(strings.Join(body, "\n"), max-fences) {
+		pieces = append(pieces, "```\n"+chunk+"\n```")
+	}
+	return pieces
+}
+
+// splitLines splits text between lines into chunks of at most max
+// characters, falling back to words and then characters for long lines
+func splitLines(text string, max int) []string {
+	var chunks []string
+	current := ""
+	add := func(piece, sep string) {
+		if current == "" {
+			current = piece
+		} else if utf8.RuneCountInString(current)+len(sep)+utf8.RuneCountInString(piece) <= max {
+			current += sep + piece
+		} else {
+			chunks = append(chunks, current)
+			current = piece
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if utf8.RuneCountInString(line) <= max {
+			add(line, "\n")
+			continue
+		}
+		first := true
+		for _, word := range strings.Fields(line) {
+			for utf8.RuneCountInString(word) > max {
+				runes := []rune(word)
+				add(string(runes[:max]), " ")
+				word = string(runes[max:])
+			}
+			if first {
+				add(word, "\n")
+				first = false
+			} else {
+				add(word, " ")
+			}
+		}
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFormatWhatsApp(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"bold", "a **bold** word", "a *bold* word"},
+		{"underscore bold", "a __very bold__ word", "a *very bold* word"},
+		{"python name", "call __init__ first", "call __init__ first"},
+		{"underscores inside a word", "snake__case__name", "snake__case__name"},
+		{"italic", "an *italic* word", "an _italic_ word"},
+		{"adjacent italics", "*a* *b*", "_a_ _b_"},
+		{"bold italic", "***both***", "*_both_*"},
+		{"strike", "~~gone~~", "~gone~"},
+		{"link", "see [docs](https://example.com)", "see docs (https://example.com)"},
+		{"bullet", "* one\n+ two", "- one\n- two"},
+		{"heading", "## The **Title**", "*The Title*"},
+		{"rule", "above\n---\nbelow", "above\n\nbelow"},
+		{"code block", "```go\nx := **y**\n```", "```\nx := **y**\n```"},
+		{"narrow table", "| a | b |\n|---|---|\n| 1 | 2 |", "```\na  b\n----\n1  2\n```"},
+		{
+			"wide table",
+			"| Name | Description |\n|---|---|\n| one | " + strings.Repeat("x", 40) + " |",
+			"*Name*: one\nDescription: " + strings.Repeat("x", 40),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatWhatsApp(tt.in); got != tt.want {
+				t.Errorf("FormatWhatsApp(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	paragraph := strings.Repeat("word ", 20)
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		parts int
+	}{
+		{"fits", "short text", 100, 1},
+		{"no limit", strings.Repeat("x", 500), 0, 1},
+		{"paragraphs", paragraph + "\n\n" + paragraph + "\n\n" + paragraph, 120, 3},
+		{"long line", strings.Repeat("word ", 100), 60, 10},
+		{"long word", strings.Repeat("x", 250), 60, 5},
+		{"code block", "```\n" + strings.Repeat("line of code\n", 20) + "```", 80, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := SplitMessage(tt.text, tt.limit)
+			if len(parts) != tt.parts {
+				t.Fatalf("got %d parts, want %d: %q", len(parts), tt.parts, parts)
+			}
+			for i, part := range parts {
+				if tt.limit > 0 && utf8.RuneCountInString(part) > tt.limit {
+					t.Errorf("part %d has %d characters, limit %d", i+1, utf8.RuneCountInString(part), tt.limit)
+				}
+				if strings.Count(part, "```")%2 != 0 {
+					t.Errorf("part %d leaves a code block open: %q", i+1, part)
+				}
+				if len(parts) > 1 && !strings.HasPrefix(part, "(") {
+					t.Errorf("part %d is not numbered: %q", i+1, part)
+				}
+			}
+		})
+	}
+}
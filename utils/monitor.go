@@ -9,16 +9,17 @@ import (
 )
 
 type Metrics struct {
-	TotalRequests  int64
-	CacheHits      int64
-	CacheMisses    int64
-	AverageLatency int64
-	FailedRequests int64
-	SlowResponses  int64
-	ResponseTimes  []time.Duration
-	ActiveSessions int64
-	MemoryUsage    uint64
-	GoroutineCount int
+	TotalRequests     int64
+	CacheHits         int64
+	CacheMisses       int64
+	CoalescedRequests int64
+	AverageLatency    int64
+	FailedRequests    int64
+	SlowResponses     int64
+	ResponseTimes     []time.Duration
+	ActiveSessions    int64
+	MemoryUsage       uint64
+	GoroutineCount    int
 }
 
 var metrics = &Metrics{}
@@ -65,6 +66,12 @@ func IncrementCacheMiss() {
 	atomic.AddInt64(&metrics.CacheMisses, 1)
 }
 
+// IncrementCoalescedRequest counts a request answered by waiting on an
+// identical in-flight model call
+func IncrementCoalescedRequest() {
+	atomic.AddInt64(&metrics.CoalescedRequests, 1)
+}
+
 func RecordLatency(duration time.Duration) {
 	atomic.StoreInt64(&metrics.AverageLatency, int64(duration))
 	if duration > 5*time.Second {
@@ -0,0 +1,243 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Access policies decide which contacts a bot answers. Blocked contacts
+// are ignored under every policy.
+const (
+	// AccessOpen answers everyone
+	AccessOpen = "open"
+	// AccessBlocklist answers everyone but blocked contacts, the same as
+	// AccessOpen
+	AccessBlocklist = "blocklist"
+	// AccessAllowlist answers allowed contacts only
+	AccessAllowlist = "allowlist"
+	// AccessOptIn asks unknown contacts for consent first
+	AccessOptIn = "opt_in"
+)
+
+// Access statuses of a contact
+const (
+	ContactAllowed   = "allowed"
+	ContactConsented = "consented"
+	ContactPending   = "pending"
+	ContactDeclined  = "declined"
+)
+
+const (
+	defaultConsentMessage   = "Hi! I'm an automated assistant. Reply \"yes\" if you'd like me to answer your messages, or \"stop\" at any time to opt out."
+	defaultConsentedMessage = "Thanks! Go ahead and ask me anything."
+	defaultDeclinedMessage  = "Okay, I won't answer your messages anymore. Reply \"yes\" if you change your mind."
+)
+
+// AccessConfig controls which contacts a bot answers
+type AccessConfig struct {
+	Policy string `json:"policy"`
+	// ConsentMessage asks an unknown contact to opt in (opt_in only)
+	ConsentMessage string `json:"consent_message"`
+	// ConsentKeyword is the reply that gives consent
+	ConsentKeyword string `json:"consent_keyword"`
+	// ConsentedMessage confirms the consent
+	ConsentedMessage string `json:"consented_message"`
+	// DeclineKeyword withdraws the consent
+	DeclineKeyword string `json:"decline_keyword"`
+	// DeclinedMessage confirms the withdrawal
+	DeclinedMessage string `json:"declined_message"`
+	// DeniedMessage is sent to contacts not on the allowlist; empty ignores
+	// them silently
+	DeniedMessage string `json:"denied_message"`
+}
+
+// checkAccess reports whether the bot may answer the sender of msg under
+// its access policy. Under AccessOptIn it also runs the consent exchange,
+// whose messages are never answered by the model.
+func (b *Bot) checkAccess(msg *events.Message) bool {
+	if b.isOwner(msg) {
+		return true
+	}
+	cfg := b.getConfig()
+	policy := cfg.Access
+	switch policy.Policy {
+	case AccessAllowlist, AccessOptIn:
+	default:
+		return true
+	}
+
+	contact := msg.Info.Sender.ToNonAD().String()
+	status, err := b.accountManager.store.GetAccess(b.botID, contact)
+	if err != nil {
+		fmt.Printf("Error checking access: %v\n", err)
+		return false
+	}
+	cooldown := time.Duration(cfg.RateLimits.NoticeCooldown)
+
+	if status == ContactAllowed {
+		return true
+	}
+	if policy.Policy == AccessAllowlist {
+		if policy.DeniedMessage != "" && b.rateLimiter.ShouldNotify("denied|"+contact, cooldown) {
+			b.sendAcknowledgment(msg.Info.Chat, policy.DeniedMessage)
+		}
+		return false
+	}
+
+	text := strings.TrimSpace(messageText(msg))
+	switch {
+	case status == ContactConsented && strings.EqualFold(text, policy.DeclineKeyword):
+		b.setAccess(contact, ContactDeclined)
+		b.sendAcknowledgment(msg.Info.Chat, policy.DeclinedMessage)
+		return false
+	case status == ContactConsented:
+		return true
+	case strings.EqualFold(text, policy.ConsentKeyword):
+		b.setAccess(contact, ContactConsented)
+		b.sendAcknowledgment(msg.Info.Chat, policy.ConsentedMessage)
+		return false
+	case status == ContactDeclined:
+		// Stay silent until the contact opts in again
+	case status == "":
+		b.setAccess(contact, ContactPending)
+		b.rateLimiter.ShouldNotify("consent|"+contact, cooldown)
+		b.sendAcknowledgment(msg.Info.Chat, policy.ConsentMessage)
+	case b.rateLimiter.ShouldNotify("consent|"+contact, cooldown):
+		b.sendAcknowledgment(msg.Info.Chat, policy.ConsentMessage)
+	}
+	return false
+}
+
+func (b *Bot) setAccess(contact, status string) {
+	if err := b.accountManager.store.SetAccess(b.botID, contact, status); err != nil {
+		fmt.Printf("Error updating access: %v\n", err)
+	}
+}
+
+// setBlocked blocks or unblocks a contact
+func (b *Bot) setBlocked(contact string, block bool) error {
+	if err := b.accountManager.store.SetBlocked(b.botID, contact, block); err != nil {
+		return err
+	}
+
+	b.moderationMux.Lock()
+	defer b.moderationMux.Unlock()
+	if block {
+		b.blocked[contact] = true
+	} else {
+		delete(b.blocked, contact)
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "allow",
+		Usage:       "<number>",
+		Description: "Let a contact use the bot",
+		OwnerOnly:   true,
+		Handler:     allowCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "revoke",
+		Usage:       "<number>",
+		Description: "Forget a contact's permission or consent",
+		OwnerOnly:   true,
+		Handler:     revokeCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "access",
+		Usage:       "[number]",
+		Description: "Show the access policy or a contact's status",
+		OwnerOnly:   true,
+		Handler:     accessCommand,
+	})
+}
+
+func allowCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	if args == "" {
+		return "Usage: " + commandPrefix + "allow <number>"
+	}
+	contact := normalizeContact(args)
+	if err := b.accountManager.store.SetAccess(b.botID, contact, ContactAllowed); err != nil {
+		return "Failed: " + err.Error()
+	}
+	return "Allowed " + contact
+}
+
+func revokeCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	if args == "" {
+		return "Usage: " + commandPrefix + "revoke <number>"
+	}
+	contact := normalizeContact(args)
+	if err := b.accountManager.store.DeleteAccess(b.botID, contact); err != nil {
+		return "Failed: " + err.Error()
+	}
+	return "Revoked " + contact
+}
+
+func accessCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	if args != "" {
+		contact := normalizeContact(args)
+		status, err := b.accountManager.store.GetAccess(b.botID, contact)
+		if err != nil {
+			return "Failed: " + err.Error()
+		}
+		if status == "" {
+			status = "unknown"
+		}
+		b.moderationMux.RLock()
+		if b.blocked[contact] {
+			status += ", blocked"
+		}
+		b.moderationMux.RUnlock()
+		return contact + ": " + status
+	}
+
+	entries, err := b.accountManager.store.ListAccess(b.botID)
+	if err != nil {
+		return "Failed: " + err.Error()
+	}
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Status]++
+	}
+	policy := b.getConfig().Access.Policy
+	if policy == "" {
+		policy = AccessOpen
+	}
+	b.moderationMux.RLock()
+	blocked := len(b.blocked)
+	b.moderationMux.RUnlock()
+	return fmt.Sprintf("Policy: %s\nAllowed %d, consented %d, pending %d, declined %d, blocked %d",
+		policy, counts[ContactAllowed], counts[ContactConsented], counts[ContactPending], counts[ContactDeclined], blocked)
+}
+
+// AccessList returns the access status of every known contact of a bot
+func (am *AccountManager) AccessList(botID string) ([]storage.ContactAccess, error) {
+	return am.store.ListAccess(botID)
+}
+
+// SetContactAccess sets a contact's access status, e.g. ContactAllowed
+func (am *AccountManager) SetContactAccess(botID, contact, status string) error {
+	return am.store.SetAccess(botID, normalizeContact(contact), status)
+}
+
+// RevokeAccess forgets a contact's access status
+func (am *AccountManager) RevokeAccess(botID, contact string) error {
+	return am.store.DeleteAccess(botID, normalizeContact(contact))
+}
+
+// SetBlocked blocks or unblocks a contact of a bot
+func (am *AccountManager) SetBlocked(botID, contact string, block bool) error {
+	contact = normalizeContact(contact)
+	if bot, exists := am.GetBot(botID); exists {
+		return bot.setBlocked(contact, block)
+	}
+	return am.store.SetBlocked(botID, contact, block)
+}
@@ -1,11 +1,13 @@
-
-
 package whatsapp
 
 import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+	"whatsapp-gpt-bot/utils"
 
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/store/sqlstore"
@@ -14,24 +16,111 @@ import (
 
 // AccountManager handles multiple WhatsApp bot instances
 type AccountManager struct {
-	container *sqlstore.Container
-	bots      map[string]*Bot
-	logger    waLog.Logger
-	mutex     sync.RWMutex
+	container  *sqlstore.Container
+	bots       map[string]*Bot
+	logger     waLog.Logger
+	mutex      sync.RWMutex
+	config     *Config
+	configPath string
+	configMux  sync.RWMutex
+	llmLimiter *LLMLimiter
+	store      *storage.Store
+	breakers   map[string]*utils.CircuitBreaker
+	breakerMux sync.Mutex
+	endpoints  *endpointPool
+	timeouts   *TimeoutManager
+	done       chan struct{}
+	campaigns  *campaignPacer
+	apiLimiter *RateLimiter
+	// loaded is set once LoadBots has registered the stored accounts
+	loaded bool
 }
 
 // NewAccountManager creates a new account manager
-func NewAccountManager(dbPath string, logger waLog.Logger) (*AccountManager, error) {
+func NewAccountManager(dbPath, configPath string, logger waLog.Logger) (*AccountManager, error) {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
 	container, err := sqlstore.New(context.Background(), "sqlite", dbPath, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create store: %v", err)
 	}
 
-	return &AccountManager{
-		container: container,
-		bots:      make(map[string]*Bot),
-		logger:    logger,
-	}, nil
+	store, err := storage.Open(dbPath)
+	if err != nil {
+		container.Close()
+		return nil, err
+	}
+
+	am := &AccountManager{
+		container:  container,
+		bots:       make(map[string]*Bot),
+		logger:     logger,
+		config:     config,
+		configPath: configPath,
+		llmLimiter: NewLLMLimiter(config.LLMMaxConcurrency),
+		store:      store,
+		breakers:   make(map[string]*utils.CircuitBreaker),
+		done:       make(chan struct{}),
+		campaigns:  newCampaignPacer(),
+		apiLimiter: NewRateLimiter(0, 0),
+	}
+	am.endpoints = newEndpointPool(func(url string) *endpointState {
+		return &endpointState{url: url, breaker: am.breaker(url), healthy: true}
+	})
+	am.timeouts = NewTimeoutManager()
+	am.loadTimeouts()
+	go am.runHealthChecks(time.Duration(config.HealthCheckInterval))
+	go am.persistTimeouts()
+	go am.runScheduler()
+	go am.runCampaigns()
+	am.apiLimiter.StartCleanup()
+
+	return am, nil
+}
+
+// botConfig resolves the configuration for a bot, falling back to the
+// defaults if its section is invalid
+func (am *AccountManager) botConfig(botID string) *BotConfig {
+	cfg, err := am.getConfig().BotConfig(botID)
+	if err != nil {
+		am.logger.Errorf("Using default config for %s: %v", botID, err)
+		return DefaultBotConfig()
+	}
+	am.llmLimiter.SetWeight(botID, cfg.LLMWeight)
+	return cfg
+}
+
+func (am *AccountManager) getConfig() *Config {
+	am.configMux.RLock()
+	defer am.configMux.RUnlock()
+	return am.config
+}
+
+// breaker returns the circuit breaker guarding a model server URL. Bots
+// talking to the same server share it.
+func (am *AccountManager) breaker(url string) *utils.CircuitBreaker {
+	am.breakerMux.Lock()
+	defer am.breakerMux.Unlock()
+
+	cb, exists := am.breakers[url]
+	if !exists {
+		cfg := am.getConfig().CircuitBreaker
+		cb = utils.NewCircuitBreaker(url, utils.BreakerConfig{
+			FailureThreshold: cfg.FailureThreshold,
+			OpenTimeout:      time.Duration(cfg.OpenTimeout),
+			HalfOpenProbes:   cfg.HalfOpenProbes,
+		})
+		am.breakers[url] = cb
+	}
+	return cb
+}
+
+// LLMStats returns the global model request limiter's state
+func (am *AccountManager) LLMStats() (inFlight, waiting, capacity int) {
+	return am.llmLimiter.Stats()
 }
 
 // CreateNewBot creates a new bot instance
@@ -39,8 +128,14 @@ func (am *AccountManager) CreateNewBot() (*Bot, error) {
 	deviceStore := am.container.NewDevice()
 	client := whatsmeow.NewClient(deviceStore, am.logger)
 
+	// The account is only known once paired, its ID is stored then
+	known, err := am.store.BotIDs()
+	if err != nil {
+		return nil, err
+	}
+
 	am.mutex.Lock()
-	botID := fmt.Sprintf("bot_%d", len(am.bots)+1)
+	botID := am.nextBotID(known)
 	bot := NewBot(client, am.container, am, botID)
 	am.bots[botID] = bot
 	am.mutex.Unlock()
@@ -48,6 +143,29 @@ func (am *AccountManager) CreateNewBot() (*Bot, error) {
 	return bot, nil
 }
 
+// nextBotID returns the lowest bot ID that is neither running nor stored
+// for another account. The caller must hold am.mutex.
+func (am *AccountManager) nextBotID(known map[string]string) string {
+	taken := make(map[string]bool, len(known))
+	for _, id := range known {
+		taken[id] = true
+	}
+	for n := 1; ; n++ {
+		id := fmt.Sprintf("bot_%d", n)
+		if _, running := am.bots[id]; !running && !taken[id] {
+			return id
+		}
+	}
+}
+
+// rememberBotID stores the bot ID of a newly paired account, so it keeps
+// the ID and its data after a restart
+func (am *AccountManager) rememberBotID(jid, botID string) {
+	if err := am.store.SetBotID(jid, botID); err != nil {
+		am.logger.Errorf("Failed to save ID of %s: %v", botID, err)
+	}
+}
+
 // ListBots returns all active bot instances
 func (am *AccountManager) ListBots() map[string]*Bot {
 	am.mutex.RLock()
@@ -94,6 +212,7 @@ func (am *AccountManager) RemoveBot(botID string) error {
 	if bot.client != nil {
 		bot.client.Disconnect()
 	}
+	bot.close()
 	delete(am.bots, botID)
 	return nil
 }
@@ -101,6 +220,10 @@ func (am *AccountManager) RemoveBot(botID string) error {
 // Close closes the account manager and all associated resources
 func (am *AccountManager) Close() error {
 	am.DisconnectAll()
+	close(am.done)
+	am.apiLimiter.Stop()
+	am.saveTimeouts()
+	am.store.Close()
 	return am.container.Close()
 }
 
@@ -111,11 +234,29 @@ func (am *AccountManager) LoadBots() error {
 		return fmt.Errorf("failed to get devices: %v", err)
 	}
 
+	// Bots keep the ID stored for their account, accounts linked before
+	// IDs were stored get the first free one in the old order
+	known, err := am.store.BotIDs()
+	if err != nil {
+		return err
+	}
+
 	for _, device := range devices {
 		client := whatsmeow.NewClient(device, am.logger)
 
 		am.mutex.Lock()
-		botID := fmt.Sprintf("bot_%d", len(am.bots)+1)
+		var jid string
+		if device.ID != nil {
+			jid = device.ID.ToNonAD().String()
+		}
+		botID, stored := known[jid]
+		if !stored {
+			botID = am.nextBotID(known)
+			if jid != "" {
+				known[jid] = botID
+				am.rememberBotID(jid, botID)
+			}
+		}
 		bot := NewBot(client, am.container, am, botID)
 		am.bots[botID] = bot
 		am.mutex.Unlock()
@@ -127,5 +268,16 @@ func (am *AccountManager) LoadBots() error {
 		}(bot)
 	}
 
+	am.mutex.Lock()
+	am.loaded = true
+	am.mutex.Unlock()
 	return nil
 }
+
+// botsLoaded reports whether the stored accounts are registered, so a bot
+// ID that isn't known belongs to a removed bot
+func (am *AccountManager) botsLoaded() bool {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+	return am.loaded
+}
@@ -0,0 +1,296 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+	"whatsapp-gpt-bot/types"
+	"whatsapp-gpt-bot/utils"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// auditListSize is how many entries /audit shows
+const auditListSize = 10
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "status",
+		Description: "Show the bot's state",
+		OwnerOnly:   true,
+		Handler:     statusCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "metrics",
+		Description: "Show request metrics",
+		OwnerOnly:   true,
+		Handler:     metricsCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "pause",
+		Usage:       "<number>",
+		Description: "Stop answering a chat",
+		OwnerOnly:   true,
+		Handler:     pauseCommand(true),
+	})
+	RegisterCommand(Command{
+		Name:        "resume",
+		Usage:       "<number>",
+		Description: "Answer a paused chat again",
+		OwnerOnly:   true,
+		Handler:     pauseCommand(false),
+	})
+	RegisterCommand(Command{
+		Name:        "block",
+		Usage:       "<number>",
+		Description: "Ignore all messages from a contact",
+		OwnerOnly:   true,
+		Handler:     blockCommand(true),
+	})
+	RegisterCommand(Command{
+		Name:        "unblock",
+		Usage:       "<number>",
+		Description: "Stop ignoring a contact",
+		OwnerOnly:   true,
+		Handler:     blockCommand(false),
+	})
+	RegisterCommand(Command{
+		Name:        "flushcache",
+		Description: "Clear the response cache",
+		OwnerOnly:   true,
+		Handler:     flushCacheCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "reload",
+		Description: "Reload the configuration file",
+		OwnerOnly:   true,
+		Handler:     reloadCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "audit",
+		Description: "Show the latest admin actions",
+		OwnerOnly:   true,
+		Handler:     auditCommand,
+	})
+}
+
+// isOwner reports whether msg comes from one of the bot's operators
+func (b *Bot) isOwner(msg *events.Message) bool {
+	return b.messagePriority(msg) == types.PriorityOwner
+}
+
+// audit records an admin action
+func (b *Bot) audit(msg *events.Message, action, args, result string) {
+	err := b.accountManager.store.AddAudit(storage.AuditEntry{
+		BotID:  b.botID,
+		Actor:  msg.Info.Sender.ToNonAD().String(),
+		Action: action,
+		Args:   args,
+		Result: result,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		fmt.Printf("Error auditing /%s: %v\n", action, err)
+	}
+}
+
+// loadModeration reads the bot's paused chats and blocked contacts
+func (b *Bot) loadModeration() {
+	paused, err := b.accountManager.store.ListPaused(b.botID)
+	if err != nil {
+		fmt.Printf("Error loading paused chats: %v\n", err)
+	}
+	blocked, err := b.accountManager.store.ListBlocked(b.botID)
+	if err != nil {
+		fmt.Printf("Error loading blocked contacts: %v\n", err)
+	}
+
+	b.moderationMux.Lock()
+	defer b.moderationMux.Unlock()
+	for _, chat := range paused {
+		b.paused[chat] = true
+	}
+	for _, contact := range blocked {
+		b.blocked[contact] = true
+	}
+}
+
+// isIgnored reports whether a message must not be answered because its
+// sender is blocked or its chat is paused. Owners are never ignored.
+func (b *Bot) isIgnored(msg *events.Message) bool {
+	if b.isOwner(msg) {
+		return false
+	}
+	b.moderationMux.RLock()
+	defer b.moderationMux.RUnlock()
+	return b.blocked[msg.Info.Sender.ToNonAD().String()] || b.paused[msg.Info.Chat.String()]
+}
+
+func statusCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	var sb strings.Builder
+
+	state := "disconnected"
+	if b.IsConnected() {
+		state = "connected"
+	}
+	fmt.Fprintf(&sb, "*%s* is %s\n", b.botID, state)
+
+	lanes := b.messageQueue.LaneStats()
+	limit, active := b.messageQueue.Concurrency()
+	fmt.Fprintf(&sb, "Queue: %d busy of %d workers, pending", active, limit)
+	for _, p := range types.Priorities {
+		fmt.Fprintf(&sb, " %s %d", p, lanes[p.String()].Pending)
+	}
+
+	inFlight, waiting, capacity := b.accountManager.LLMStats()
+	fmt.Fprintf(&sb, "\nModel capacity: %d in flight, %d waiting, %d slots\n", inFlight, waiting, capacity)
+
+	sb.WriteString("Endpoints:")
+	for _, ep := range b.endpointConfigs() {
+		s := b.accountManager.endpoint(ep.URL).status()
+		health := "up"
+		if !s.Healthy {
+			health = "down"
+		}
+		fmt.Fprintf(&sb, "\n- %s: %s, %.0f ms, breaker %s", ep.provider(), health, s.LatencyMs, s.Breaker)
+	}
+
+	if hours := b.getConfig().BusinessHours; hours.Enabled {
+		if hours.isOpen(time.Now()) {
+			sb.WriteString("\nBusiness hours: open")
+		} else {
+			fmt.Fprintf(&sb, "\nBusiness hours: closed (%s), opens %s", hours.AwayMode,
+				hours.nextOpening(time.Now()).Format("Mon 15:04"))
+		}
+	}
+
+	b.mutex.RLock()
+	conversations := len(b.conversations)
+	b.mutex.RUnlock()
+	b.moderationMux.RLock()
+	fmt.Fprintf(&sb, "\nChats: %d, paused %d, with a human %d, blocked contacts %d",
+		conversations, len(b.paused), len(b.humanMode), len(b.blocked))
+	b.moderationMux.RUnlock()
+	return sb.String()
+}
+
+func metricsCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	m := utils.GetMetrics()
+	t := utils.GetTimeoutMetrics()
+	return fmt.Sprintf("*Metrics*\nRequests: %d (%d failed, %d slow)\nCache: %d hits, %d misses, %d coalesced\nAverage latency: %s\nTimeouts: %d, current timeout %s\nActive sessions: %d\nMemory: %d MB, %d goroutines",
+		atomic.LoadInt64(&m.TotalRequests), atomic.LoadInt64(&m.FailedRequests), atomic.LoadInt64(&m.SlowResponses),
+		atomic.LoadInt64(&m.CacheHits), atomic.LoadInt64(&m.CacheMisses), atomic.LoadInt64(&m.CoalescedRequests),
+		time.Duration(atomic.LoadInt64(&m.AverageLatency)).Round(time.Millisecond),
+		atomic.LoadInt64(&t.TimeoutCount), time.Duration(atomic.LoadInt64(&t.AverageTimeout)),
+		atomic.LoadInt64(&m.ActiveSessions), m.MemoryUsage/1024/1024, m.GoroutineCount)
+}
+
+func pauseCommand(pause bool) CommandHandler {
+	name := "pause"
+	if !pause {
+		name = "resume"
+	}
+	return func(b *Bot, msg *events.Message, chatID, args string) string {
+		if args == "" {
+			return "Usage: " + commandPrefix + name + " <number>"
+		}
+		chat := normalizeContact(args)
+		if err := b.accountManager.store.SetPaused(b.botID, chat, pause); err != nil {
+			return "Failed: " + err.Error()
+		}
+
+		b.moderationMux.Lock()
+		if pause {
+			b.paused[chat] = true
+		} else {
+			delete(b.paused, chat)
+		}
+		b.moderationMux.Unlock()
+
+		if pause {
+			return "Paused " + chat
+		}
+		return "Resumed " + chat
+	}
+}
+
+func blockCommand(block bool) CommandHandler {
+	name := "block"
+	if !block {
+		name = "unblock"
+	}
+	return func(b *Bot, msg *events.Message, chatID, args string) string {
+		if args == "" {
+			return "Usage: " + commandPrefix + name + " <number>"
+		}
+		contact := normalizeContact(args)
+		if err := b.setBlocked(contact, block); err != nil {
+			return "Failed: " + err.Error()
+		}
+
+		if block {
+			return "Blocked " + contact
+		}
+		return "Unblocked " + contact
+	}
+}
+
+func flushCacheCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	b.cache.Clear()
+	b.mutex.Lock()
+	b.responseCache = make(map[string]CachedResponse)
+	b.mutex.Unlock()
+	return "Cache cleared."
+}
+
+func reloadCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	if err := b.accountManager.ReloadConfig(); err != nil {
+		return "Reload failed: " + err.Error()
+	}
+	return "Configuration reloaded."
+}
+
+func auditCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	entries, err := b.accountManager.store.ListAudit(b.botID, auditListSize)
+	if err != nil {
+		return "Failed: " + err.Error()
+	}
+	if len(entries) == 0 {
+		return "No admin actions yet."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("*Latest admin actions*")
+	for _, e := range entries {
+		fmt.Fprintf(&sb, "\n%s %s: /%s %s", e.Time.Format("2006-01-02 15:04"), e.Actor, e.Action, e.Args)
+	}
+	return sb.String()
+}
+
+// ReloadConfig reads the configuration file again and applies it to every
+// bot. Breakers and the health check interval keep their settings until
+// restart.
+func (am *AccountManager) ReloadConfig() error {
+	config, err := LoadConfig(am.configPath)
+	if err != nil {
+		return err
+	}
+
+	am.configMux.Lock()
+	am.config = config
+	am.configMux.Unlock()
+	am.llmLimiter.SetCapacity(config.LLMMaxConcurrency)
+
+	for id, bot := range am.ListBots() {
+		bot.setConfig(am.botConfig(id))
+	}
+	return nil
+}
+
+// AuditLog returns the latest admin actions of a bot
+func (am *AccountManager) AuditLog(botID string, limit int) ([]storage.AuditEntry, error) {
+	return am.store.ListAudit(botID, limit)
+}
@@ -0,0 +1,322 @@
+package whatsapp
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	wtypes "go.mau.fi/whatsmeow/types"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/proto"
+)
+
+// Message types accepted by the HTTP API
+const (
+	APIText     = "text"
+	APIImage    = "image"
+	APIDocument = "document"
+)
+
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+	defaultAPIRate        = 1
+	defaultAPIBurst       = 10
+	// idempotencySaveAttempts is how often saving a sent message's key is
+	// tried before the request fails
+	idempotencySaveAttempts = 3
+)
+
+// Errors of SendAPIMessage, mapped to HTTP statuses by the dashboard
+var (
+	ErrBotNotFound         = errors.New("bot not found")
+	ErrBotForbidden        = errors.New("token may not use this bot")
+	ErrBotOffline          = errors.New("bot is not connected")
+	ErrInvalidMessage      = errors.New("invalid message")
+	ErrRateLimited         = errors.New("rate limit exceeded")
+	ErrRequestInProgress   = errors.New("a request with this idempotency key is still in progress")
+	ErrIdempotencyMismatch = errors.New("idempotency key was already used for a different request")
+)
+
+// APIConfig controls the HTTP API for sending messages. Without tokens the
+// API rejects every request.
+type APIConfig struct {
+	Tokens []APIToken `json:"tokens"`
+	// IdempotencyTTL is how long an idempotency key is remembered
+	IdempotencyTTL Duration `json:"idempotency_ttl"`
+}
+
+// APIToken lets another system send messages through the bots
+type APIToken struct {
+	// Name identifies the token in logs, limits and idempotency keys
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// Bots are the bot IDs the token may use, all bots if empty
+	Bots []string `json:"bots"`
+	// RateLimit limits the messages sent with the token, 1 per second
+	// with bursts of 10 if not set
+	RateLimit RateLimit `json:"rate_limit"`
+}
+
+// OutgoingMessage is a message to send through the HTTP API
+type OutgoingMessage struct {
+	// To is a phone number or JID
+	To string `json:"to"`
+	// Type is APIText, APIImage or APIDocument; text if empty
+	Type string `json:"type"`
+	Text string `json:"text"`
+	// Caption is shown under an image or document
+	Caption string `json:"caption"`
+	// Data is the file of an image or document, base64 in JSON
+	Data     []byte `json:"data"`
+	MimeType string `json:"mime_type"`
+	FileName string `json:"file_name"`
+}
+
+// APIResult is the outcome of SendAPIMessage
+type APIResult struct {
+	MessageID string `json:"message_id"`
+	// Duplicate is set when the idempotency key was used before and the
+	// earlier message's ID is returned instead of sending again
+	Duplicate bool `json:"duplicate"`
+}
+
+// validate checks that every token has a unique name, which keys its rate
+// limit and idempotency keys, and a secret
+func (c APIConfig) validate() error {
+	names := make(map[string]bool, len(c.Tokens))
+	for i, t := range c.Tokens {
+		if strings.TrimSpace(t.Name) == "" {
+			return fmt.Errorf("api token %d has no name", i+1)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("api token name %q is used more than once", t.Name)
+		}
+		names[t.Name] = true
+		if t.Token == "" {
+			return fmt.Errorf("api token %q has no token", t.Name)
+		}
+	}
+	return nil
+}
+
+// AllowsBot reports whether the token may use a bot
+func (t APIToken) AllowsBot(botID string) bool {
+	return len(t.Bots) == 0 || slices.Contains(t.Bots, botID)
+}
+
+// APIToken returns the configured token matching secret
+func (am *AccountManager) APIToken(secret string) (APIToken, bool) {
+	if secret == "" {
+		return APIToken{}, false
+	}
+	for _, t := range am.getConfig().API.Tokens {
+		if t.Token != "" && subtle.ConstantTimeCompare([]byte(t.Token), []byte(secret)) == 1 {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
+
+// SendAPIMessage sends msg through a bot on behalf of an API token. A
+// request repeated with the same idempotency key returns the first
+// message's ID without sending it again; fingerprint identifies the
+// request so a key can't be reused for a different one. ctx bounds the
+// upload of files.
+func (am *AccountManager) SendAPIMessage(ctx context.Context, token APIToken, botID, key, fingerprint string, msg OutgoingMessage) (APIResult, error) {
+	if !token.AllowsBot(botID) {
+		return APIResult{}, ErrBotForbidden
+	}
+	bot, exists := am.GetBot(botID)
+	if !exists {
+		return APIResult{}, ErrBotNotFound
+	}
+	if err := msg.validate(); err != nil {
+		return APIResult{}, err
+	}
+
+	if key != "" {
+		ttl := time.Duration(am.getConfig().API.IdempotencyTTL)
+		if ttl <= 0 {
+			ttl = defaultIdempotencyTTL
+		}
+		now := time.Now()
+		prev, claimed, err := am.store.ClaimAPIRequest(storage.APIRequest{
+			Token:       token.Name,
+			Key:         key,
+			Fingerprint: fingerprint,
+			BotID:       botID,
+			CreatedAt:   now,
+		}, now.Add(-ttl))
+		if err != nil {
+			return APIResult{}, err
+		}
+		switch {
+		case claimed:
+		case prev.Fingerprint != fingerprint || prev.BotID != botID:
+			return APIResult{}, ErrIdempotencyMismatch
+		case prev.MessageID == "":
+			return APIResult{}, ErrRequestInProgress
+		default:
+			return APIResult{MessageID: prev.MessageID, Duplicate: true}, nil
+		}
+	}
+
+	// The key is only kept for messages that were sent
+	release := func() {
+		if key == "" {
+			return
+		}
+		if err := am.store.ReleaseAPIRequest(token.Name, key); err != nil {
+			fmt.Printf("Error releasing idempotency key: %v\n", err)
+		}
+	}
+
+	limit, burst := rate.Limit(token.RateLimit.PerSecond), token.RateLimit.Burst
+	if limit <= 0 {
+		limit, burst = defaultAPIRate, defaultAPIBurst
+	}
+	if !am.apiLimiter.AllowWith(token.Name, limit, burst) {
+		release()
+		return APIResult{}, ErrRateLimited
+	}
+	if !bot.IsConnected() {
+		release()
+		return APIResult{}, ErrBotOffline
+	}
+
+	id, err := bot.sendOutgoing(ctx, msg)
+	if err != nil {
+		release()
+		return APIResult{}, err
+	}
+	if key != "" {
+		if err := am.completeAPIRequest(token.Name, key, id); err != nil {
+			// The key stays claimed, a retry can't send the message twice
+			return APIResult{}, fmt.Errorf("message %s was sent but its idempotency key could not be saved: %v", id, err)
+		}
+	}
+	fmt.Printf("API token %s sent %s message %s to %s\n", token.Name, msg.Type, id, msg.To)
+	return APIResult{MessageID: id}, nil
+}
+
+// completeAPIRequest saves the message ID for an idempotency key, retrying
+// briefly since the message has already been sent
+func (am *AccountManager) completeAPIRequest(token, key, messageID string) error {
+	var err error
+	for attempt := 0; attempt < idempotencySaveAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		if err = am.store.CompleteAPIRequest(token, key, messageID); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// validate checks msg and fills in its defaults
+func (msg *OutgoingMessage) validate() error {
+	if strings.TrimSpace(msg.To) == "" {
+		return fmt.Errorf("%w: to is required", ErrInvalidMessage)
+	}
+	if msg.Type == "" {
+		msg.Type = APIText
+	}
+	switch msg.Type {
+	case APIText:
+		if strings.TrimSpace(msg.Text) == "" {
+			return fmt.Errorf("%w: text is required", ErrInvalidMessage)
+		}
+	case APIImage, APIDocument:
+		if len(msg.Data) == 0 {
+			return fmt.Errorf("%w: data is required for %s messages", ErrInvalidMessage, msg.Type)
+		}
+		if msg.MimeType == "" {
+			msg.MimeType = http.DetectContentType(msg.Data)
+		}
+		if msg.Type == APIImage && !strings.HasPrefix(msg.MimeType, "image/") {
+			return fmt.Errorf("%w: %s is not an image", ErrInvalidMessage, msg.MimeType)
+		}
+		if msg.Type == APIDocument && msg.FileName == "" {
+			msg.FileName = "document"
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidMessage, msg.Type)
+	}
+	return nil
+}
+
+// sendOutgoing sends an API message as is, without splitting or
+// reformatting it, and returns its WhatsApp message ID
+func (b *Bot) sendOutgoing(ctx context.Context, msg OutgoingMessage) (string, error) {
+	chat, err := wtypes.ParseJID(normalizeContact(msg.To))
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid recipient %s", ErrInvalidMessage, msg.To)
+	}
+
+	var content *waE2E.Message
+	history := msg.Text
+	switch msg.Type {
+	case APIText:
+		content = &waE2E.Message{Conversation: proto.String(msg.Text)}
+	case APIImage:
+		uploaded, err := b.client.Upload(ctx, msg.Data, whatsmeow.MediaImage)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload image: %v", err)
+		}
+		content = &waE2E.Message{
+			ImageMessage: &waE2E.ImageMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    proto.Uint64(uploaded.FileLength),
+				Mimetype:      proto.String(msg.MimeType),
+				Caption:       proto.String(msg.Caption),
+			},
+		}
+		history = strings.TrimSpace("[image] " + msg.Caption)
+	case APIDocument:
+		uploaded, err := b.client.Upload(ctx, msg.Data, whatsmeow.MediaDocument)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload document: %v", err)
+		}
+		content = &waE2E.Message{
+			DocumentMessage: &waE2E.DocumentMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    proto.Uint64(uploaded.FileLength),
+				Mimetype:      proto.String(msg.MimeType),
+				FileName:      proto.String(msg.FileName),
+				Title:         proto.String(msg.FileName),
+				Caption:       proto.String(msg.Caption),
+			},
+		}
+		history = strings.TrimSpace("[document " + msg.FileName + "] " + msg.Caption)
+	}
+
+	resp, err := b.send(chat, content)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep the notification in the history so replies to it make sense
+	chatID := chat.String()
+	if err := b.initConversation(chatID); err == nil {
+		b.appendHistory(chatID, BotMessage{Role: "assistant", Content: history, Time: time.Now()})
+	}
+	return resp.ID, nil
+}
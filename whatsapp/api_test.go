@@ -0,0 +1,88 @@
+package whatsapp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAPIConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  []APIToken
+		wantErr bool
+	}{
+		{"no tokens", nil, false},
+		{"valid", []APIToken{{Name: "crm", Token: "a"}, {Name: "shop", Token: "b"}}, false},
+		{"missing name", []APIToken{{Token: "a"}}, true},
+		{"blank name", []APIToken{{Name: " ", Token: "a"}}, true},
+		{"duplicate name", []APIToken{{Name: "crm", Token: "a"}, {Name: "crm", Token: "b"}}, true},
+		{"missing secret", []APIToken{{Name: "crm"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := APIConfig{Tokens: tt.tokens}.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPITokenAllowsBot(t *testing.T) {
+	tests := []struct {
+		bots  []string
+		botID string
+		want  bool
+	}{
+		{nil, "1", true},
+		{[]string{"1", "2"}, "2", true},
+		{[]string{"1", "2"}, "3", false},
+		{[]string{"1"}, "", false},
+	}
+	for _, tt := range tests {
+		if got := (APIToken{Bots: tt.bots}).AllowsBot(tt.botID); got != tt.want {
+			t.Errorf("token for %v: AllowsBot(%q) = %v, want %v", tt.bots, tt.botID, got, tt.want)
+		}
+	}
+}
+
+func TestOutgoingMessageValidate(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		msg      OutgoingMessage
+		wantErr  bool
+		wantType string
+		wantMime string
+		wantFile string
+	}{
+		{"text by default", OutgoingMessage{To: "1555", Text: "hi"}, false, APIText, "", ""},
+		{"missing recipient", OutgoingMessage{Text: "hi"}, true, "", "", ""},
+		{"empty text", OutgoingMessage{To: "1555", Text: " "}, true, "", "", ""},
+		{"image", OutgoingMessage{To: "1555", Type: APIImage, Data: png}, false, APIImage, "image/png", ""},
+		{"image without data", OutgoingMessage{To: "1555", Type: APIImage}, true, "", "", ""},
+		{"image that isn't one", OutgoingMessage{To: "1555", Type: APIImage, Data: []byte("plain text")}, true, "", "", ""},
+		{"document", OutgoingMessage{To: "1555", Type: APIDocument, Data: []byte("a,b\n"), MimeType: "text/csv"}, false, APIDocument, "text/csv", "document"},
+		{"named document", OutgoingMessage{To: "1555", Type: APIDocument, Data: []byte("x"), FileName: "x.txt"}, false, APIDocument, "text/plain; charset=utf-8", "x.txt"},
+		{"unknown type", OutgoingMessage{To: "1555", Type: "video", Data: []byte("x")}, true, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.msg
+			err := msg.validate()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMessage) {
+					t.Errorf("validate = %v, want ErrInvalidMessage", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			if msg.Type != tt.wantType || msg.MimeType != tt.wantMime || msg.FileName != tt.wantFile {
+				t.Errorf("validated message has type %q, mime type %q, file name %q, want %q, %q, %q",
+					msg.Type, msg.MimeType, msg.FileName, tt.wantType, tt.wantMime, tt.wantFile)
+			}
+		})
+	}
+}
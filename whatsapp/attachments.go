@@ -0,0 +1,47 @@
+package whatsapp
+
+import (
+	"context"
+
+	"whatsapp-gpt-bot/utils"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	wtypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// AttachmentConfig decides when parts of a reply are sent as files. Long
+// code and tables are hard to read in a chat bubble on a phone. A zero
+// threshold disables that check.
+type AttachmentConfig struct {
+	// CodeLines is the longest code block sent inline
+	CodeLines int `json:"code_lines"`
+	// TableRows and TableColumns are the largest table sent inline
+	TableRows    int `json:"table_rows"`
+	TableColumns int `json:"table_columns"`
+}
+
+// sendDocument uploads a file and sends it to chat
+func (b *Bot) sendDocument(chat wtypes.JID, file utils.Attachment) error {
+	uploaded, err := b.client.Upload(context.Background(), file.Data, whatsmeow.MediaDocument)
+	if err != nil {
+		return err
+	}
+
+	msg := &waE2E.Message{
+		DocumentMessage: &waE2E.DocumentMessage{
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			Mimetype:      proto.String(file.MimeType),
+			FileName:      proto.String(file.FileName),
+			Title:         proto.String(file.FileName),
+		},
+	}
+	_, err = b.send(chat, msg)
+	return err
+}
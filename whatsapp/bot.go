@@ -4,6 +4,7 @@ package whatsapp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/skip2/go-qrcode"
 	"fmt"
 	"net/http"
@@ -26,28 +27,39 @@ import (
 type BotMessage struct {
 	Role    string
 	Content string
-	Time    time.Time
+	// Reasoning is the model's thinking behind an answer, kept for
+	// debugging and never sent back to the model
+	Reasoning string
+	// Operator marks a message a human sent from the phone
+	Operator bool
+	Time     time.Time
 }
 
 type Conversation struct {
 	Messages   []BotMessage
 	LastActive time.Time
 	Summary    string
+	// Truncated is set while the last answer was cut off and the rest can
+	// be requested with "more"
+	Truncated bool
+	// ShowReasoning sends a condensed reasoning trace with each answer,
+	// toggled with /think
+	ShowReasoning bool
+	// Persona, Language and Model are the chat's settings from /persona,
+	// /lang and /model
+	Persona  string
+	Language string
+	Model    string
 }
 
 type CacheEntry struct {
 	Response  string
+	Reasoning string
+	Truncated bool
 	Timestamp time.Time
 	UseCount  int
 }
 
-type TimeoutManager struct {
-	responseTimes     []time.Duration
-	timeoutCount      int
-	mutex             sync.RWMutex
-	averageResponseTime time.Duration
-}
-
 type CachedResponse struct {
 	Content  string
 	Tokens   int
@@ -67,8 +79,22 @@ type Bot struct {
 	cacheMux      sync.RWMutex
 	responseCache map[string]CachedResponse
 	rateLimiter   *RateLimiter
+	chatLimiter   *RateLimiter
+	botLimiter    *RateLimiter
+	debouncer     *debouncer
+	turns         *turnRegistry
 	accountManager *AccountManager
 	botID         string
+	config        *BotConfig
+	configMux     sync.RWMutex
+	done          chan struct{}
+	paused        map[string]bool
+	blocked       map[string]bool
+	humanMode     map[string]time.Time
+	moderationMux sync.RWMutex
+	sent          *sentMessages
+	awayNotices   *awayNotices
+	rules         *ruleEngine
 }
 
 func NewBot(client *whatsmeow.Client, db *sqlstore.Container, am *AccountManager, id string) *Bot {
@@ -77,21 +103,45 @@ func NewBot(client *whatsmeow.Client, db *sqlstore.Container, am *AccountManager
 		db:             db,
 		conversations:  make(map[string]*Conversation),
 		cache:          cache.NewCache(1000),
-		timeouts:       &TimeoutManager{},
+		timeouts:       am.timeouts,
 		messageQueue:   queue.NewQueue(10, 5, 5*time.Second),
 		responseCache:  make(map[string]CachedResponse),
 		rateLimiter:    NewRateLimiter(0.5, 1), // Allow 1 request every 2 seconds
+		chatLimiter:    NewRateLimiter(0, 0),
+		botLimiter:     NewRateLimiter(0, 0),
 		accountManager: am,
 		botID:          id,
+		config:         am.botConfig(id),
+		done:           make(chan struct{}),
+		paused:         make(map[string]bool),
+		blocked:        make(map[string]bool),
+		humanMode:      make(map[string]time.Time),
+		sent:           newSentMessages(),
+		awayNotices:    newAwayNotices(),
+		rules:          newRuleEngine(),
 	}
+	bot.debouncer = newDebouncer(bot.flushDebounced)
+	bot.turns = newTurnRegistry()
+	bot.loadModeration()
+	bot.loadHumanMode()
+	bot.applyQueueConfig()
+	bot.applyRateLimitConfig()
+	bot.rateLimiter.StartCleanup()
+	bot.chatLimiter.StartCleanup()
+	bot.botLimiter.StartCleanup()
+	bot.messageQueue.SetHandler(bot.processQueuedMessage)
 
 	// Register event handlers
 	client.AddEventHandler(bot.handleMessage)
 	client.AddEventHandler(bot.handleQREvent)
 	client.AddEventHandler(bot.handleLoggedOut)
+	client.AddEventHandler(bot.handlePairSuccess)
+	client.AddEventHandler(bot.handleChatPresence)
+	client.AddEventHandler(bot.handleConnected)
 
 	// Start cache cleanup routine
 	go bot.cleanupCache()
+	go bot.runDeferredReplies()
 
 	return bot
 }
@@ -106,6 +156,15 @@ func (b *Bot) Disconnect() {
 	b.client.Disconnect()
 }
 
+// close stops the bot's background routines once it has been removed
+func (b *Bot) close() {
+	b.rateLimiter.Stop()
+	b.chatLimiter.Stop()
+	b.botLimiter.Stop()
+	b.cache.Stop()
+	close(b.done)
+}
+
 // IsConnected returns whether the client is connected
 func (b *Bot) IsConnected() bool {
 	return b.client.IsConnected()
@@ -125,13 +184,21 @@ const (
 	INITIAL_TIMEOUT = 15 * time.Second
 	MAX_TIMEOUT     = 60 * time.Second
 	MAX_RETRIES     = 2
+
+	// MAX_WHATSAPP_CHARS is the longest text sent in one message, longer
+	// replies are split into parts
+	MAX_WHATSAPP_CHARS = 4096
 )
 
 type LMResponse struct {
 	Choices []struct {
 		Message struct {
 			Content string `json:"content"`
+			// ReasoningContent is where some servers put the thinking
+			// of reasoning models
+			ReasoningContent string `json:"reasoning_content"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
 }
 
@@ -150,6 +217,13 @@ func (b *Bot) handleLoggedOut(evt interface{}) {
 	}
 }
 
+// handlePairSuccess stores the bot's ID for the account it was linked to
+func (b *Bot) handlePairSuccess(evt interface{}) {
+	if v, ok := evt.(*events.PairSuccess); ok {
+		b.accountManager.rememberBotID(v.ID.ToNonAD().String(), b.botID)
+	}
+}
+
 func (b *Bot) handleMessage(evt interface{}) {
 	switch v := evt.(type) {
 	case *events.Message:
@@ -167,18 +241,79 @@ func (b *Bot) handleMessage(evt interface{}) {
 		// The main filter logic:
 		// - Ignore group messages
 		// - Ignore poll updates
-		// - Ignore messages from self, UNLESS the chat is with self (which is the case for business accounts)
-        if v.Info.IsGroup || v.Message.GetPollUpdateMessage() != nil || (v.Info.IsFromMe && v.Info.Chat.String() != botJID.String()) {
+		// - Messages from self are admin commands or an operator's messages
+        if v.Info.IsGroup || v.Message.GetPollUpdateMessage() != nil {
+			return
+		}
+		if v.Info.IsFromMe {
+			// Commands the owner types into their own chat go to the admin
+			// commands, anything else sent from the phone may be a human
+			// operator taking over
+			if b.isSelfChat(v.Info.Chat) && !b.sent.has(v.Info.ID) {
+				if name, _, ok := parseCommand(messageText(v)); ok {
+					if _, known := b.command(v, name); known {
+						if err := b.initConversation(v.Info.Chat.String()); err != nil {
+							fmt.Printf("Error handling message: %v\n", err)
+							return
+						}
+						b.handleCommand(v, v.Info.Chat.String())
+						return
+					}
+				}
+			}
+			b.handleOperatorMessage(v)
 			return
 		}
 
-		// Rate limit messages
-		if !b.rateLimiter.Allow(v.Info.Sender.String()) {
-			b.sendAcknowledgment(v.Info.Chat, "You are sending messages too fast. Please wait a moment.")
+		// Blocked contacts and paused chats are not answered at all
+		if b.isIgnored(v) {
 			return
 		}
 
 		chatID := v.Info.Chat.String()
+
+		// Any message from a recent campaign's recipient counts as a reply
+		b.markCampaignReply(v)
+
+		// While a human handles the chat the bot only listens
+		if b.inHumanMode(chatID) {
+			b.recordHumanModeMessage(v, chatID)
+			return
+		}
+
+		// Contacts the access policy doesn't admit get no answer
+		if !b.checkAccess(v) {
+			return
+		}
+
+		// Commands are answered right away, ahead of the model
+		if name, _, ok := parseCommand(v.Message.GetConversation()); ok {
+			if _, known := b.command(v, name); known {
+				if !b.checkRateLimits(v) {
+					return
+				}
+				if err := b.initConversation(chatID); err != nil {
+					fmt.Printf("Error handling message: %v\n", err)
+					return
+				}
+				b.handleCommand(v, chatID)
+				return
+			}
+		}
+
+		// Outside business hours the away mode decides what happens
+		if b.handleAfterHours(v, chatID) {
+			return
+		}
+
+		// Rate limit messages. Text that gets debounced is limited once per
+		// merged turn instead, so a burst isn't rejected piece by piece.
+		debounceWindow := time.Duration(b.getConfig().DebounceWindow)
+		debounced := debounceWindow > 0 && v.Message.GetConversation() != ""
+		if !debounced && !b.checkRateLimits(v) {
+			return
+		}
+
 		utils.IncrementActiveSessions()
 		defer func() {
 			b.mutex.RLock()
@@ -195,17 +330,26 @@ func (b *Bot) handleMessage(evt interface{}) {
 			return
 		}
 
+		var msgType types.MessageType
 		switch {
 		case v.Message.GetConversation() != "":
-			go b.handleTextMessage(v, chatID)
+			msgType = types.TextMessage
 		case v.Message.GetImageMessage() != nil:
-			go b.handleImageMessage(v)
+			msgType = types.ImageMessage
 		case v.Message.GetDocumentMessage() != nil:
-			go b.handleDocumentMessage(v)
+			msgType = types.DocumentMessage
 		case v.Message.GetTemplateButtonReplyMessage() != nil:
 			// Handle template button replies
 			v.Message.Conversation = proto.String(v.Message.GetTemplateButtonReplyMessage().GetSelectedID())
-			go b.handleTextMessage(v, chatID)
+			msgType = types.TextMessage
+		default:
+			return
+		}
+
+		if debounced {
+			b.debounceTextMessage(v, debounceWindow)
+		} else {
+			b.enqueueMessage(v, msgType)
 		}
 
 		err := b.client.SendChatPresence(v.Info.Chat, wtypes.ChatPresenceComposing, wtypes.ChatPresenceMediaText)
@@ -215,6 +359,92 @@ func (b *Bot) handleMessage(evt interface{}) {
     }
 }
 
+// enqueueMessage hands an incoming message to the queue in its priority lane
+func (b *Bot) enqueueMessage(msg *events.Message, msgType types.MessageType) {
+	b.messageQueue.Enqueue(types.Message{
+		ID:        msg.Info.ID,
+		Type:      msgType,
+		Content:   msg,
+		Timestamp: time.Now(),
+		ChatID:    msg.Info.Chat.String(),
+		Priority:  b.messagePriority(msg),
+	})
+}
+
+// processQueuedMessage is the queue handler, it runs on one of the queue's workers
+func (b *Bot) processQueuedMessage(msg types.Message) {
+	evt, ok := msg.Content.(*events.Message)
+	if !ok {
+		return
+	}
+
+	// A human may have taken the chat over while the message was queued
+	if b.inHumanMode(msg.ChatID) {
+		b.recordHumanModeMessage(evt, msg.ChatID)
+		return
+	}
+
+	switch msg.Type {
+	case types.TextMessage:
+		b.handleTextMessage(evt, msg.ChatID)
+	case types.ImageMessage:
+		b.handleImageMessage(evt)
+	case types.DocumentMessage:
+		b.handleDocumentMessage(evt)
+	}
+}
+
+// messagePriority decides which queue lane an incoming message goes to
+func (b *Bot) messagePriority(msg *events.Message) types.Priority {
+	cfg := b.getConfig()
+
+	switch {
+	case msg.Info.IsFromMe || containsJID(cfg.OwnerJIDs, msg.Info.Sender):
+		return types.PriorityOwner
+	case containsJID(cfg.VIPContacts, msg.Info.Sender):
+		return types.PriorityVIP
+	case msg.Info.Chat.Server == wtypes.BroadcastServer || containsJID(cfg.BulkContacts, msg.Info.Sender):
+		return types.PriorityBulk
+	}
+	return types.PriorityNormal
+}
+
+// isSelfChat reports whether chat is the account's chat with itself
+func (b *Bot) isSelfChat(chat wtypes.JID) bool {
+	chat = chat.ToNonAD()
+	if id := b.client.Store.ID; id != nil && chat == id.ToNonAD() {
+		return true
+	}
+	return !b.client.Store.LID.IsEmpty() && chat == b.client.Store.LID.ToNonAD()
+}
+
+// getConfig returns the bot's current configuration
+func (b *Bot) getConfig() *BotConfig {
+	b.configMux.RLock()
+	defer b.configMux.RUnlock()
+	return b.config
+}
+
+// setConfig replaces the bot's configuration and applies the queue and rate
+// limit settings
+func (b *Bot) setConfig(cfg *BotConfig) {
+	b.configMux.Lock()
+	b.config = cfg
+	b.configMux.Unlock()
+	b.applyQueueConfig()
+	b.applyRateLimitConfig()
+}
+
+func (b *Bot) applyQueueConfig() {
+	cfg := b.getConfig()
+	for _, p := range types.Priorities {
+		if weight, exists := cfg.LaneWeights[p.String()]; exists {
+			b.messageQueue.SetLaneWeight(p, weight)
+		}
+	}
+	b.messageQueue.SetWorkerBounds(cfg.MinWorkers, cfg.MaxWorkers)
+}
+
 func (b *Bot) initConversation(chatID string) error {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
@@ -237,76 +467,186 @@ defer func() {
 	utils.RecordTimeout(retrySuccess)
 }()
 
-	// Enqueue message for processing
-	b.messageQueue.Enqueue(types.Message{
-		ID:        msg.Info.ID,
-		Type:      types.TextMessage,
-		Content:   msg.Message.GetConversation(),
-		Timestamp: time.Now(),
-		ChatID:    chatID,
-	})
-
 	userMsg := msg.Message.GetConversation()
 	if userMsg == "" {
 		return
 	}
 
+	// A newer message from the same chat may cancel this turn, or this
+	// turn may take over one that is still running
+	turn := b.turns.begin(chatID, userMsg, b.getConfig().SupersedePolicy)
+	defer b.turns.finish(chatID, turn)
+	if b.turns.isSuperseded(turn) {
+		return
+	}
+	userMsg = turn.text
+
 	b.client.SendChatPresence(msg.Info.Chat, wtypes.ChatPresenceComposing, wtypes.ChatPresenceMediaText)
 
-	if cachedResp, found := b.getCachedResponse(userMsg); found {
+	// "more" after an answer that was cut off asks for the rest of it
+	if b.isMoreRequest(chatID, userMsg) {
+		if b.checkQuota(msg) {
+			b.continueAnswer(turn.ctx, msg, chatID)
+		}
+		return
+	}
+
+	// "remind me ..." schedules a reminder instead of asking the model
+	if b.handleReminder(msg, chatID, userMsg) {
+		return
+	}
+
+	// Rules answer common questions without a model call
+	if reply, matched := b.ruleReply(msg, userMsg); matched {
+		if b.inHumanMode(chatID) {
+			b.recordHumanModeMessage(msg, chatID)
+			return
+		}
+		if !b.turns.commit(turn) {
+			return
+		}
+		b.appendHistory(chatID, BotMessage{Role: "user", Content: userMsg, Time: time.Now()})
+		b.appendHistory(chatID, BotMessage{Role: "assistant", Content: reply, Time: time.Now()})
+		if err := b.sendReply(msg.Info.Chat, reply, nil); err != nil {
+			fmt.Printf("Error sending rule reply: %v\n", err)
+		}
+		return
+	}
+
+	// Only answers that don't depend on a chat's history are cached and
+	// shared with other chats
+	cacheKey, cacheable := b.cacheKey(chatID, userMsg)
+	cachedResp, found := "", false
+	if cacheable {
+		cachedResp, found = b.getCachedResponse(cacheKey)
+	}
+	if found {
 		utils.IncrementCacheHit()
-		if err := b.sendAcknowledgment(msg.Info.Chat, cachedResp); err == nil {
+		if b.inHumanMode(chatID) {
+			b.recordHumanModeMessage(msg, chatID)
+			return
+		}
+		if !b.turns.commit(turn) {
+			return
+		}
+		if err := b.sendReply(msg.Info.Chat, cachedResp, nil); err == nil {
 			return
 		}
 	}
 	utils.IncrementCacheMiss()
 
-	timeout := b.timeouts.getOptimalTimeout()
-	var response string
-	var tokens int
-	var latency time.Duration
-	var err error
+	if !b.checkQuota(msg) {
+		return
+	}
 
-	for retries := 0; retries <= MAX_RETRIES; retries++ {
-		if retries > 0 {
-			timeout = time.Duration(float64(timeout) * 1.5)
-			if timeout > MAX_TIMEOUT {
-				timeout = MAX_TIMEOUT
-			}
-			b.sendAcknowledgment(msg.Info.Chat, fmt.Sprintf("Retrying with longer timeout (%ds)...", int(timeout.Seconds())))
+	request := func() (interface{}, error) {
+		response, err := b.requestWithRetries(turn.ctx, msg.Info.Chat, userMsg, chatID)
+		if err != nil {
+			return nil, err
 		}
+		entry := &CacheEntry{
+			Response:  response.content,
+			Reasoning: response.reasoning,
+			Truncated: response.truncated,
+			Timestamp: time.Now(),
+			UseCount:  1,
+		}
+		if response.empty() {
+			// Returned as an error so it isn't cached
+			return entry, errEmptyAnswer
+		}
+		return entry, nil
+	}
 
-		response, tokens, latency, err = b.makeAIRequest(userMsg, chatID, timeout)
-		if err == nil {
-			utils.RecordTimeout(true)
-			utils.RecordLMStudioMetrics(latency, tokens)
+	// Identical prompts arriving at the same time share one model call
+	var value interface{}
+	var shared bool
+	var err error
+	if !cacheable {
+		value, err = request()
+	}
+	for attempt := 0; cacheable && attempt < 2; attempt++ {
+		value, shared, err = b.cache.Do(cacheKey, 24*time.Hour, request)
+		// If the call we waited on was cancelled by its own chat, make our own
+		if !shared || !errors.Is(err, context.Canceled) {
 			break
 		}
+	}
 
-		if retries == MAX_RETRIES || !isTimeoutError(err) {
-			retrySuccess = true
+	if errors.Is(err, errEmptyAnswer) {
+		err = nil
+	}
+	if b.turns.isInterrupted(turn) {
+		// An operator answers this chat now, keep the question in the
+		// history for when the bot takes over again
+		return
+	}
+	if !b.turns.commit(turn) {
+		// The reply is stale, the newer turn answers both messages
+		if !shared {
+			b.dropUserMessage(chatID, userMsg)
+		}
+		return
+	}
+	if err != nil {
+		retrySuccess = true
 		utils.RecordTimeout(true)
-			utils.IncrementFailedRequest()
-			errorMsg := "I'm having trouble processing your request right now. Please try again."
-			if isTimeoutError(err) {
-				errorMsg = "The response is still taking too long. Please try a shorter message."
-			}
-			b.sendAcknowledgment(msg.Info.Chat, errorMsg)
+		utils.IncrementFailedRequest()
+		if isDeferrable(err) && b.deferReply(msg, chatID, userMsg) {
 			return
 		}
+		errorMsg := "I'm having trouble processing your request right now. Please try again."
+		if errors.Is(err, utils.ErrCircuitOpen) {
+			errorMsg = b.getConfig().UnavailableMessage
+		} else if isTimeoutError(err) {
+			errorMsg = "The response is still taking too long. Please try a shorter message."
+		}
+		b.sendAcknowledgment(msg.Info.Chat, errorMsg)
+		return
+	}
+	entry := value.(*CacheEntry)
+	response := entry.Response
+	answer := completion{content: response, reasoning: entry.Reasoning, truncated: entry.Truncated}
+
+	if shared {
+		utils.IncrementCoalescedRequest()
+	}
+	b.recordUsage(msg, estimateTokens(userMsg)+estimateTokens(response))
+
+	// An operator took over while the answer was generated, only the
+	// question stays in the history
+	if b.inHumanMode(chatID) {
+		if shared {
+			b.recordHumanModeMessage(msg, chatID)
+		}
+		return
 	}
 
-	b.cacheResponse(userMsg, response)
 	b.mutex.Lock()
-	b.conversations[chatID].Messages = append(b.conversations[chatID].Messages, BotMessage{
-		Role:    "assistant",
-		Content: response,
-		Time:    time.Now(),
-	})
+	if shared {
+		// The model call ran on another chat's context, record our side of it
+		b.conversations[chatID].Messages = append(b.conversations[chatID].Messages, BotMessage{
+			Role:    "user",
+			Content: userMsg,
+			Time:    time.Now(),
+		})
+	}
+	// An empty answer leaves only the question in the history
+	if !answer.empty() {
+		b.conversations[chatID].Messages = append(b.conversations[chatID].Messages, BotMessage{
+			Role:      "assistant",
+			Content:   response,
+			Reasoning: entry.Reasoning,
+			Time:      time.Now(),
+		})
+		b.conversations[chatID].Truncated = entry.Truncated
+	}
 	b.mutex.Unlock()
 
-	replyMsg := utils.CreateTextMessage(response)
-	if _, err := b.client.SendMessage(context.Background(), msg.Info.Chat, replyMsg); err != nil {
+	b.sendReasoning(msg.Info.Chat, chatID, entry.Reasoning)
+
+	reply := b.replyText(answer)
+	if err := b.sendReply(msg.Info.Chat, reply, nil); err != nil {
 		fmt.Printf("Error sending message: %v\n", err)
 		return
 	}
@@ -318,95 +658,105 @@ defer func() {
 	}()
 }
 
-func (b *Bot) makeAIRequest(userMsg, chatID string, timeout time.Duration) (string, int, time.Duration, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+// requestWithRetries asks the model for a reply, retrying timeouts with a
+// longer deadline
+func (b *Bot) requestWithRetries(ctx context.Context, chat wtypes.JID, userMsg, chatID string) (completion, error) {
+	timeout := b.requestTimeout(RequestChat, b.promptChars(chatID, userMsg))
 
-	respChan := make(chan struct {
-		content string
-		tokens  int
-		latency time.Duration
-	}, 1)
-	errChan := make(chan error, 1)
+	for retries := 0; ; retries++ {
+		if retries > 0 {
+			timeout = time.Duration(float64(timeout) * 1.5)
+			if timeout > MAX_TIMEOUT {
+				timeout = MAX_TIMEOUT
+			}
+			b.sendAcknowledgment(chat, fmt.Sprintf("Retrying with longer timeout (%ds)...", int(timeout.Seconds())))
+		}
 
-	lmStart := time.Now()
+		response, tokens, latency, err := b.makeAIRequest(ctx, userMsg, chatID, timeout)
+		b.observeLatency(ctx, latency, err)
+		if err == nil {
+			utils.RecordTimeout(true)
+			utils.RecordLMStudioMetrics(latency, tokens)
+			return response, nil
+		}
 
-	go func() {
-		b.mutex.Lock()
-		conv := b.conversations[chatID]
-		if len(conv.Messages) > MAX_HISTORY {
-			conv.Messages = conv.Messages[len(conv.Messages)-MAX_HISTORY:]
+		if retries == MAX_RETRIES || !isTimeoutError(err) || ctx.Err() != nil {
+			return completion{}, err
 		}
+	}
+}
+
+// observeLatency feeds the outcome of a model call to the worker pool.
+// Only what the backend did counts: cancelled turns and requests the
+// limiter or an open breaker never let through say nothing about its load.
+func (b *Bot) observeLatency(ctx context.Context, latency time.Duration, err error) {
+	if ctx.Err() != nil || errors.Is(err, utils.ErrCircuitOpen) {
+		return
+	}
+	b.messageQueue.ObserveLatency(latency, err)
+}
+
+func (b *Bot) makeAIRequest(parent context.Context, userMsg, chatID string, timeout time.Duration) (completion, int, time.Duration, error) {
+	// Wait for a slot before starting the clock, time spent queued behind
+	// other bots is not model latency
+	release, err := b.accountManager.llmLimiter.Acquire(parent, b.botID, chatID)
+	if err != nil {
+		return completion{}, 0, 0, err
+	}
+	defer release()
+
+	// Record the prompt before the request starts, so a cancelled turn can
+	// take it back out of the history
+	b.mutex.Lock()
+	conv := b.conversations[chatID]
+	if len(conv.Messages) > MAX_HISTORY {
+		conv.Messages = conv.Messages[len(conv.Messages)-MAX_HISTORY:]
+	}
 
+	// Retries reuse the prompt that is already there
+	if n := len(conv.Messages); n == 0 || conv.Messages[n-1].Role != "user" || conv.Messages[n-1].Content != userMsg {
 		conv.Messages = append(conv.Messages, BotMessage{
 			Role:    "user",
 			Content: userMsg,
 			Time:    time.Now(),
 		})
+	}
 
-		messages := make([]map[string]string, len(conv.Messages))
-		for i, msg := range conv.Messages {
-			messages[i] = map[string]string{
-				"role":    msg.Role,
-				"content": msg.Content,
-			}
-		}
-		b.mutex.Unlock()
-
-		// Summarize conversation if it's too long
-		if len(conv.Messages) > MAX_HISTORY {
-			go b.summarizeConversation(chatID)
-		}
+	messages := b.chatMessages(conv)
+	historyLen := len(conv.Messages)
+	b.mutex.Unlock()
 
-		reqBody := map[string]interface{}{
-			"messages":   messages,
-			"max_tokens": MAX_TOKENS,
-			"model":      "local-model",
-			"stream":     false,
-		}
+	// Summarize conversation if it's too long
+	if historyLen > MAX_HISTORY {
+		go b.summarizeConversation(chatID)
+	}
 
-		jsonData, err := json.Marshal(reqBody)
-		if err != nil {
-			errChan <- err
-			return
-		}
+	respChan := make(chan struct {
+		result  completion
+		tokens  int
+		latency time.Duration
+	}, 1)
+	errChan := make(chan error, 1)
 
-		req, err := http.NewRequestWithContext(ctx, "POST", LM_STUDIO_URL, strings.NewReader(string(jsonData)))
-		if err != nil {
-			errChan <- err
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
+	lmStart := time.Now()
 
-		resp, err := http.DefaultClient.Do(req)
+	go func() {
+		result, err := b.completeWithContinuation(parent, RequestChat, chatID, messages, b.getConfig().Continuation.MaxContinuations, timeout)
 		if err != nil {
 			errChan <- err
 			return
 		}
-		defer resp.Body.Close()
 
 		latency := time.Since(lmStart)
-		b.timeouts.updateResponseTime(latency)
-
-		var lmResp LMResponse
-		if err := json.NewDecoder(resp.Body).Decode(&lmResp); err != nil {
-			errChan <- err
-			return
-		}
 
-		if len(lmResp.Choices) > 0 {
-			tokens := len(strings.Split(lmResp.Choices[0].Message.Content, " "))
-			respChan <- struct {
-				content string
-				tokens  int
-				latency time.Duration
-			}{
-				content: lmResp.Choices[0].Message.Content,
-				tokens:  tokens,
-				latency: latency,
-			}
-		} else {
-			errChan <- fmt.Errorf("no response from AI")
+		respChan <- struct {
+			result  completion
+			tokens  int
+			latency time.Duration
+		}{
+			result:  result,
+			tokens:  len(strings.Split(result.content, " ")),
+			latency: latency,
 		}
 	}()
 
@@ -415,25 +765,27 @@ func (b *Bot) makeAIRequest(userMsg, chatID string, timeout time.Duration) (stri
 		// Store response before returning
 		b.mutex.Lock()
 		b.responseCache[chatID] = CachedResponse{
-			Content:   resp.content,
+			Content:   resp.result.content,
 			Tokens:    resp.tokens,
 			Latency:   resp.latency,
 			Timestamp: time.Now(),
 		}
 		b.mutex.Unlock()
-		return resp.content, resp.tokens, resp.latency, nil
+		return resp.result, resp.tokens, resp.latency, nil
 	case err := <-errChan:
-		return "", 0, 0, err
+		return completion{}, 0, 0, err
 	// unreachable code removed or refactored as per warning at lines 134 and 136-173
-	case <-ctx.Done():
-		b.timeouts.recordTimeout()
-		return "", 0, 0, ctx.Err()
+	case <-parent.Done():
+		return completion{}, 0, 0, parent.Err()
 	}
 }
 
-func (b *Bot) makeIndependentAIRequest(prompt string, timeout time.Duration) (string, int, time.Duration, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+func (b *Bot) makeIndependentAIRequest(kind, prompt string, timeout time.Duration) (string, int, time.Duration, error) {
+	release, err := b.accountManager.llmLimiter.Acquire(context.Background(), b.botID, "")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer release()
 
 	respChan := make(chan struct {
 		content string
@@ -449,55 +801,23 @@ func (b *Bot) makeIndependentAIRequest(prompt string, timeout time.Duration) (st
 			{"role": "user", "content": prompt},
 		}
 
-		reqBody := map[string]interface{}{
-			"messages":   messages,
-			"max_tokens": MAX_TOKENS,
-			"model":      "local-model",
-			"stream":     false,
-		}
-
-		jsonData, err := json.Marshal(reqBody)
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		req, err := http.NewRequestWithContext(ctx, "POST", LM_STUDIO_URL, strings.NewReader(string(jsonData)))
-		if err != nil {
-			errChan <- err
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := http.DefaultClient.Do(req)
+		result, err := b.completeWithContinuation(context.Background(), kind, "", messages, b.getConfig().Continuation.MaxContinuations, timeout)
 		if err != nil {
 			errChan <- err
 			return
 		}
-		defer resp.Body.Close()
+		content := result.content
 
 		latency := time.Since(lmStart)
-		b.timeouts.updateResponseTime(latency)
-
-		var lmResp LMResponse
-		if err := json.NewDecoder(resp.Body).Decode(&lmResp); err != nil {
-			errChan <- err
-			return
-		}
 
-		if len(lmResp.Choices) > 0 {
-			tokens := len(strings.Split(lmResp.Choices[0].Message.Content, " "))
-			respChan <- struct {
-				content string
-				tokens  int
-				latency time.Duration
-			}{
-				content: lmResp.Choices[0].Message.Content,
-				tokens:  tokens,
-				latency: latency,
-			}
-		} else {
-			errChan <- fmt.Errorf("no response from AI")
+		respChan <- struct {
+			content string
+			tokens  int
+			latency time.Duration
+		}{
+			content: content,
+			tokens:  len(strings.Split(content, " ")),
+			latency: latency,
 		}
 	}()
 
@@ -506,12 +826,60 @@ func (b *Bot) makeIndependentAIRequest(prompt string, timeout time.Duration) (st
 		return resp.content, resp.tokens, resp.latency, nil
 	case err := <-errChan:
 		return "", 0, 0, err
-	case <-ctx.Done():
-		b.timeouts.recordTimeout()
-		return "", 0, 0, ctx.Err()
 	}
 }
 
+// doChatCompletion sends messages to one model server and returns the
+// first choice
+func (b *Bot) doChatCompletion(ctx context.Context, ep EndpointConfig, messages []map[string]string) (completion, error) {
+	reqBody := map[string]interface{}{
+		"messages":   messages,
+		"max_tokens": MAX_TOKENS,
+		"model":      ep.modelName(),
+		"stream":     false,
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return completion{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ep.URL, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return completion{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ep.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+ep.APIKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return completion{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return completion{}, fmt.Errorf("model server returned %s", resp.Status)
+	}
+
+	var lmResp LMResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lmResp); err != nil {
+		return completion{}, err
+	}
+
+	if len(lmResp.Choices) == 0 {
+		return completion{}, fmt.Errorf("no response from AI")
+	}
+	choice := lmResp.Choices[0]
+	content, reasoning := splitReasoning(choice.Message.Content)
+	return completion{
+		content:   content,
+		reasoning: joinReasoning(choice.Message.ReasoningContent, reasoning),
+		truncated: choice.FinishReason == "length",
+	}, nil
+}
+
 func (b *Bot) handleImageMessage(msg *events.Message) {
 	img := msg.Message.GetImageMessage()
 	if img != nil {
@@ -546,7 +914,7 @@ func (b *Bot) handleDocumentMessage(msg *events.Message) {
 
 func (b *Bot) sendAcknowledgment(chat wtypes.JID, text string) error {
 	msg := utils.CreateTextMessage(text)
-	_, err := b.client.SendMessage(context.Background(), chat, msg)
+	_, err := b.send(chat, msg)
 	return err
 }
 
@@ -559,6 +927,28 @@ func (b *Bot) cleanupCache() {
 	}
 }
 
+// cacheKey returns the key a prompt's answer is cached and shared under.
+// Answers depend on the chat's model, persona and language, so those are
+// part of it. cacheable is false if the chat has history the answer would
+// depend on.
+func (b *Bot) cacheKey(chatID, prompt string) (key string, cacheable bool) {
+	b.mutex.RLock()
+	var persona, language, model string
+	cacheable = true
+	if conv, exists := b.conversations[chatID]; exists {
+		persona, language, model = conv.Persona, conv.Language, conv.Model
+		cacheable = len(conv.Messages) == 0 && conv.Summary == ""
+	}
+	b.mutex.RUnlock()
+	if away := b.awayPersona(); away != "" {
+		persona = away
+	}
+	if persona == "" && language == "" && model == "" {
+		return prompt, cacheable
+	}
+	return strings.Join([]string{model, persona, language, prompt}, "\x00"), cacheable
+}
+
 func (b *Bot) getCachedResponse(query string) (string, bool) {
 	if value, exists := b.cache.Get(query); exists {
 		entry := value.(*CacheEntry)
@@ -570,49 +960,6 @@ func (b *Bot) getCachedResponse(query string) (string, bool) {
 	return "", false
 }
 
-func (b *Bot) cacheResponse(query, response string) {
-	b.cache.Set(query, &CacheEntry{
-		Response:  response,
-		Timestamp: time.Now(),
-		UseCount:  1,
-	}, 24*time.Hour)
-}
-
-func (tm *TimeoutManager) updateResponseTime(duration time.Duration) {
-	// Add mutex to TimeoutManager struct if not present
-	// var mutex sync.RWMutex
-	// Add averageResponseTime field if not present
-	// var averageResponseTime time.Duration
-	// Implementation below assumes these fields exist
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
-
-	if tm.averageResponseTime == 0 {
-		tm.averageResponseTime = duration
-	} else {
-		tm.averageResponseTime = time.Duration(float64(tm.averageResponseTime)*0.7 + float64(duration)*0.3)
-	}
-}
-
-func (tm *TimeoutManager) getOptimalTimeout() time.Duration {
-	tm.mutex.RLock()
-	defer tm.mutex.RUnlock()
-
-	if tm.averageResponseTime == 0 {
-		return INITIAL_TIMEOUT
-	}
-
-	timeout := tm.averageResponseTime * 2
-
-	if timeout < INITIAL_TIMEOUT {
-		return INITIAL_TIMEOUT
-	}
-	if timeout > MAX_TIMEOUT {
-		return MAX_TIMEOUT
-	}
-	return timeout
-}
-
 func isTimeoutError(err error) bool {
 	if err == nil {
 		return false
@@ -620,12 +967,6 @@ func isTimeoutError(err error) bool {
 	return err == context.DeadlineExceeded || strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "deadline exceeded")
 }
 
-func (tm *TimeoutManager) recordTimeout() {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
-	tm.timeoutCount++
-}
-
 func (b *Bot) summarizeConversation(chatID string) {
 	b.mutex.Lock()
 	conv, exists := b.conversations[chatID]
@@ -645,11 +986,16 @@ func (b *Bot) summarizeConversation(chatID string) {
 
 	// Make a request to the AI to summarize the conversation in a separate goroutine
 	go func() {
-		summary, _, _, err := b.makeIndependentAIRequest(prompt, DEFAULT_TIMEOUT)
+		timeout := b.requestTimeout(RequestSummary, len(prompt))
+		summary, _, _, err := b.makeIndependentAIRequest(RequestSummary, prompt, timeout)
 		if err != nil {
 			fmt.Printf("Error summarizing conversation: %v\n", err)
 			return
 		}
+		if strings.TrimSpace(summary) == "" {
+			// All reasoning, keep the history as it is
+			return
+		}
 
 		// Update the conversation with the summary
 		b.mutex.Lock()
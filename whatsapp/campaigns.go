@@ -0,0 +1,440 @@
+package whatsapp
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+
+	wtypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	// campaignTick is how often running campaigns are checked for a
+	// recipient that is due
+	campaignTick           = time.Second
+	defaultCampaignDelay   = 10 * time.Second
+	defaultCampaignJitter  = 5 * time.Second
+	defaultCampaignHourly  = 60
+	defaultCampaignReplies = 72 * time.Hour
+	defaultCampaignFails   = 5
+	// maxCampaignBackoff caps how often the delay doubles after failures
+	maxCampaignBackoff = 6
+)
+
+// CampaignConfig paces a bot's broadcast campaigns. Sending too much too
+// fast gets a number banned, so a bot sends one campaign message at a time
+// however many campaigns are running.
+type CampaignConfig struct {
+	// Delay is the minimum time between two campaign messages
+	Delay Duration `json:"delay"`
+	// Jitter is a random extra delay of up to this much, so the messages
+	// don't arrive at a fixed rhythm
+	Jitter Duration `json:"jitter"`
+	// MaxPerHour caps the campaign messages a bot sends in any hour
+	MaxPerHour int `json:"max_per_hour"`
+	// ReplyWindow is how long after a campaign message a contact's next
+	// message counts as a reply to it
+	ReplyWindow Duration `json:"reply_window"`
+	// BusinessHoursOnly holds campaigns while the bot is closed
+	BusinessHoursOnly bool `json:"business_hours_only"`
+	// MaxFailures pauses a campaign after this many sends in a row failed,
+	// the account may be rate limited or banned
+	MaxFailures int `json:"max_failures"`
+}
+
+// campaignPacer spaces out the campaign messages of each bot
+type campaignPacer struct {
+	mutex sync.Mutex
+	next  map[string]time.Time
+	sent  map[string][]time.Time
+	// failed counts each bot's failed sends in a row
+	failed map[string]int
+	// streaks holds the recipients of each campaign whose sends failed
+	// in a row
+	streaks map[int64][]string
+}
+
+func newCampaignPacer() *campaignPacer {
+	return &campaignPacer{
+		next:    make(map[string]time.Time),
+		sent:    make(map[string][]time.Time),
+		failed:  make(map[string]int),
+		streaks: make(map[int64][]string),
+	}
+}
+
+// ready reports whether botID may send a campaign message now
+func (p *campaignPacer) ready(botID string, cfg CampaignConfig, now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if now.Before(p.next[botID]) {
+		return false
+	}
+	max := cfg.MaxPerHour
+	if max <= 0 {
+		max = defaultCampaignHourly
+	}
+	recent := p.sent[botID][:0]
+	for _, t := range p.sent[botID] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	p.sent[botID] = recent
+	return len(recent) < max
+}
+
+// record notes a message botID tried to send to contact and when the next
+// one may follow. The delay doubles with each failure in a row. It returns
+// the campaign's recipients whose sends failed in a row, including this
+// one, or nil after a success.
+func (p *campaignPacer) record(botID string, campaignID int64, contact string, failed bool, cfg CampaignConfig, now time.Time) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if failed {
+		p.failed[botID]++
+		p.streaks[campaignID] = append(p.streaks[campaignID], contact)
+	} else {
+		delete(p.failed, botID)
+		delete(p.streaks, campaignID)
+	}
+
+	delay := time.Duration(cfg.Delay)
+	if delay <= 0 {
+		delay = defaultCampaignDelay
+	}
+	delay <<= min(p.failed[botID], maxCampaignBackoff)
+	if jitter := time.Duration(cfg.Jitter); jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	p.next[botID] = now.Add(delay)
+	p.sent[botID] = append(p.sent[botID], now)
+	return p.streaks[campaignID]
+}
+
+// forget drops a campaign's failure streak
+func (p *campaignPacer) forget(campaignID int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.streaks, campaignID)
+}
+
+// runCampaigns delivers the messages of running campaigns until the
+// manager is closed
+func (am *AccountManager) runCampaigns() {
+	ticker := time.NewTicker(campaignTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			am.sendCampaignMessages()
+		case <-am.done:
+			return
+		}
+	}
+}
+
+// sendCampaignMessages sends the next message of each running campaign
+// whose bot is connected and not held back by its pacing. Campaigns of the
+// same bot take turns, oldest first.
+func (am *AccountManager) sendCampaignMessages() {
+	campaigns, err := am.store.RunningCampaigns()
+	if err != nil {
+		fmt.Printf("Error loading campaigns: %v\n", err)
+		return
+	}
+	now := time.Now()
+	for _, c := range campaigns {
+		bot, exists := am.GetBot(c.BotID)
+		if !exists || !bot.IsConnected() {
+			continue
+		}
+		cfg := bot.getConfig()
+		if cfg.Campaigns.BusinessHoursOnly && !cfg.BusinessHours.isOpen(now) {
+			continue
+		}
+		if !am.campaigns.ready(c.BotID, cfg.Campaigns, now) {
+			continue
+		}
+
+		r, found, err := am.store.NextRecipient(c.ID)
+		if err != nil {
+			fmt.Printf("Error loading recipients of campaign %d: %v\n", c.ID, err)
+			continue
+		}
+		if !found {
+			// A pause or cancel in the meantime wins
+			if finished, err := am.store.SetCampaignStatus(c.ID, storage.CampaignDone, storage.CampaignRunning); err != nil {
+				fmt.Printf("Error finishing campaign %d: %v\n", c.ID, err)
+			} else if finished {
+				fmt.Printf("Campaign %d (%s) finished\n", c.ID, c.Name)
+			}
+			continue
+		}
+
+		// The campaign may have been paused or cancelled since it was loaded
+		if status, err := am.store.CampaignStatus(c.ID); err != nil || status != storage.CampaignRunning {
+			continue
+		}
+
+		status, reason, sendErr := bot.sendCampaignMessage(c, r)
+		if err := am.store.SetRecipientStatus(c.ID, r.Contact, status, reason); err != nil {
+			fmt.Printf("Error updating campaign %d: %v\n", c.ID, err)
+		}
+		if status != storage.RecipientSent && sendErr == nil {
+			// Not sent for a reason of the recipient's own, no time was spent
+			continue
+		}
+		streak := am.campaigns.record(c.BotID, c.ID, r.Contact, sendErr != nil, cfg.Campaigns, now)
+		max := cfg.Campaigns.MaxFailures
+		if max <= 0 {
+			max = defaultCampaignFails
+		}
+		if len(streak) >= max {
+			am.pauseFailingCampaign(c, streak, sendErr)
+		}
+	}
+}
+
+// pauseFailingCampaign pauses a campaign whose last sends all failed and
+// queues their recipients again, so resuming retries them
+func (am *AccountManager) pauseFailingCampaign(c storage.Campaign, contacts []string, lastErr error) {
+	am.campaigns.forget(c.ID)
+	if _, err := am.store.SetCampaignStatus(c.ID, storage.CampaignPaused, storage.CampaignRunning); err != nil {
+		fmt.Printf("Error pausing campaign %d: %v\n", c.ID, err)
+		return
+	}
+	for _, contact := range contacts {
+		if err := am.store.SetRecipientStatus(c.ID, contact, storage.RecipientQueued, ""); err != nil {
+			fmt.Printf("Error requeueing %s in campaign %d: %v\n", contact, c.ID, err)
+		}
+	}
+	fmt.Printf("Campaign %d (%s) paused after %d failed sends in a row, last error: %v\n", c.ID, c.Name, len(contacts), lastErr)
+}
+
+// sendCampaignMessage sends a campaign's message to one recipient and
+// returns the recipient's new status, with the reason if it failed. err is
+// set if WhatsApp didn't take the message, rather than the recipient being
+// skipped.
+func (b *Bot) sendCampaignMessage(c storage.Campaign, r storage.Recipient) (status, reason string, err error) {
+	b.moderationMux.RLock()
+	blocked := b.blocked[r.Contact]
+	b.moderationMux.RUnlock()
+	if blocked {
+		return storage.RecipientFailed, "blocked", nil
+	}
+	if status, err := b.accountManager.store.GetAccess(b.botID, r.Contact); err == nil && status == ContactDeclined {
+		return storage.RecipientFailed, "opted out", nil
+	}
+
+	text, err := renderCampaign(c.Template, r)
+	if err != nil {
+		return storage.RecipientFailed, err.Error(), nil
+	}
+	chat, err := wtypes.ParseJID(r.Contact)
+	if err != nil {
+		return storage.RecipientFailed, "invalid contact", nil
+	}
+	if err := b.sendText(chat, text, nil); err != nil {
+		fmt.Printf("Error sending campaign %d to %s: %v\n", c.ID, r.Contact, err)
+		return storage.RecipientFailed, err.Error(), err
+	}
+
+	// Keep the announcement in the history so replies to it make sense
+	if err := b.initConversation(r.Contact); err == nil {
+		b.appendHistory(r.Contact, BotMessage{Role: "assistant", Content: text, Time: time.Now()})
+	}
+	return storage.RecipientSent, "", nil
+}
+
+// renderCampaign fills in a campaign's template for one recipient. The
+// template sees the recipient's variables by name and .phone.
+func renderCampaign(text string, r storage.Recipient) (string, error) {
+	tmpl, err := template.New("campaign").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	data := map[string]string{"phone": strings.TrimSuffix(r.Contact, "@s.whatsapp.net")}
+	for k, v := range r.Vars {
+		data[k] = v
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// markCampaignReply records that the sender of msg answered a campaign
+func (b *Bot) markCampaignReply(msg *events.Message) {
+	window := time.Duration(b.getConfig().Campaigns.ReplyWindow)
+	if window <= 0 {
+		window = defaultCampaignReplies
+	}
+	if err := b.accountManager.store.MarkReplied(b.botID, msg.Info.Sender.ToNonAD().String(), window); err != nil {
+		fmt.Printf("Error recording campaign reply: %v\n", err)
+	}
+}
+
+// ReadRecipientsCSV reads campaign recipients from CSV with a header row.
+// The column named phone, number, contact or jid (the first column if none
+// is) holds the contact; the other columns become template variables named
+// after their header.
+func ReadRecipientsCSV(r io.Reader) ([]storage.Recipient, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("CSV needs a header row and at least one recipient")
+	}
+
+	header := rows[0]
+	contactCol := 0
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "phone", "number", "contact", "jid":
+			contactCol = i
+		}
+	}
+
+	var recipients []storage.Recipient
+	for line, row := range rows[1:] {
+		if contactCol >= len(row) || strings.TrimSpace(row[contactCol]) == "" {
+			return nil, fmt.Errorf("line %d has no contact", line+2)
+		}
+		vars := make(map[string]string)
+		for i, value := range row {
+			if i != contactCol && i < len(header) {
+				vars[strings.TrimSpace(header[i])] = strings.TrimSpace(value)
+			}
+		}
+		recipients = append(recipients, storage.Recipient{
+			Contact: normalizeContact(strings.NewReplacer(" ", "", "-", "").Replace(row[contactCol])),
+			Vars:    vars,
+		})
+	}
+	return recipients, nil
+}
+
+// TaggedRecipients returns a bot's contacts with a tag as campaign
+// recipients
+func (am *AccountManager) TaggedRecipients(botID, tag string) ([]storage.Recipient, error) {
+	contacts, err := am.store.ContactsWithTag(botID, tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("no contacts tagged %q", tag)
+	}
+	recipients := make([]storage.Recipient, len(contacts))
+	for i, contact := range contacts {
+		recipients[i] = storage.Recipient{Contact: contact}
+	}
+	return recipients, nil
+}
+
+// TagContact adds or removes a tag of a bot's contact
+func (am *AccountManager) TagContact(botID, contact, tag string, tagged bool) error {
+	if tag == "" {
+		return fmt.Errorf("tag is empty")
+	}
+	return am.store.SetTag(botID, normalizeContact(contact), tag, tagged)
+}
+
+// CreateCampaign stores a draft campaign of a bot. It is sent once started.
+func (am *AccountManager) CreateCampaign(botID, name, text string, recipients []storage.Recipient) (int64, error) {
+	if _, exists := am.GetBot(botID); !exists {
+		return 0, fmt.Errorf("bot %s not found", botID)
+	}
+	if strings.TrimSpace(text) == "" {
+		return 0, fmt.Errorf("message template is empty")
+	}
+	if len(recipients) == 0 {
+		return 0, fmt.Errorf("campaign has no recipients")
+	}
+	// Catch template mistakes before anything is sent
+	for _, r := range recipients {
+		if _, err := renderCampaign(text, r); err != nil {
+			return 0, fmt.Errorf("invalid template for %s: %v", r.Contact, err)
+		}
+	}
+	if name == "" {
+		name = "campaign " + time.Now().Format("2006-01-02 15:04")
+	}
+	return am.store.AddCampaign(storage.Campaign{
+		BotID:     botID,
+		Name:      name,
+		Template:  text,
+		CreatedAt: time.Now(),
+	}, recipients)
+}
+
+// StartCampaign starts a draft campaign or resumes a paused one
+func (am *AccountManager) StartCampaign(id int64) error {
+	return am.moveCampaign(id, storage.CampaignRunning, storage.CampaignDraft, storage.CampaignPaused)
+}
+
+// PauseCampaign holds a running campaign until it is resumed
+func (am *AccountManager) PauseCampaign(id int64) error {
+	return am.moveCampaign(id, storage.CampaignPaused, storage.CampaignRunning)
+}
+
+// CancelCampaign stops a campaign for good; queued recipients stay queued
+// in the report
+func (am *AccountManager) CancelCampaign(id int64) error {
+	return am.moveCampaign(id, storage.CampaignCancelled, storage.CampaignDraft, storage.CampaignRunning, storage.CampaignPaused)
+}
+
+// moveCampaign changes a campaign's status if it is in one of the from
+// statuses
+func (am *AccountManager) moveCampaign(id int64, to string, from ...string) error {
+	moved, err := am.store.SetCampaignStatus(id, to, from...)
+	if err != nil {
+		return err
+	}
+	if !moved {
+		c, err := am.store.GetCampaign(id)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("campaign %d is %s", id, c.Status)
+	}
+	return nil
+}
+
+// Campaign returns a campaign with its recipient counts
+func (am *AccountManager) Campaign(id int64) (storage.Campaign, error) {
+	return am.store.GetCampaign(id)
+}
+
+// Campaigns returns a bot's campaigns, those of every bot if botID is
+// empty
+func (am *AccountManager) Campaigns(botID string) ([]storage.Campaign, error) {
+	return am.store.ListCampaigns(botID)
+}
+
+// CampaignReport returns a campaign with its recipients, optionally only
+// those with the given status
+func (am *AccountManager) CampaignReport(id int64, status string) (storage.Campaign, []storage.Recipient, error) {
+	c, err := am.store.GetCampaign(id)
+	if err != nil {
+		return storage.Campaign{}, nil, err
+	}
+	recipients, err := am.store.ListRecipients(id, status)
+	return c, recipients, err
+}
@@ -0,0 +1,84 @@
+package whatsapp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"whatsapp-gpt-bot/storage"
+)
+
+func TestRenderCampaign(t *testing.T) {
+	recipient := storage.Recipient{
+		Contact: "15551234567@s.whatsapp.net",
+		Vars:    map[string]string{"name": "Ana", "city": "Lisbon"},
+	}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"plain", "Hello!", "Hello!", false},
+		{"variables", "Hi {{.name}} from {{.city}}", "Hi Ana from Lisbon", false},
+		{"phone", "Your number is {{.phone}}", "Your number is 15551234567", false},
+		{"missing variable", "Hi {{.nickname}}!", "Hi !", false},
+		{"invalid template", "Hi {{.name", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderCampaign(tt.template, recipient)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderCampaign error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderCampaign = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRecipientsCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []storage.Recipient
+		wantErr bool
+	}{
+		{
+			"phone column",
+			"name,phone\nAna,+1 555-123-4567\n",
+			[]storage.Recipient{{Contact: "15551234567@s.whatsapp.net", Vars: map[string]string{"name": "Ana"}}},
+			false,
+		},
+		{
+			"first column by default",
+			"mobile, city\n15550000001, Lisbon\n15550000002, Porto\n",
+			[]storage.Recipient{
+				{Contact: "15550000001@s.whatsapp.net", Vars: map[string]string{"city": "Lisbon"}},
+				{Contact: "15550000002@s.whatsapp.net", Vars: map[string]string{"city": "Porto"}},
+			},
+			false,
+		},
+		{
+			"jid kept",
+			"JID\n123@g.us\n",
+			[]storage.Recipient{{Contact: "123@g.us", Vars: map[string]string{}}},
+			false,
+		},
+		{"header only", "phone\n", nil, true},
+		{"missing contact", "name,phone\nAna\n", nil, true},
+		{"empty contact", "phone,name\n ,Ana\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadRecipientsCSV(strings.NewReader(tt.csv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadRecipientsCSV error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadRecipientsCSV = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package whatsapp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// commandPrefix starts every chat command
+const commandPrefix = "/"
+
+// CommandHandler runs a command and returns the reply to send, empty for
+// none. args is the text after the command name.
+type CommandHandler func(b *Bot, msg *events.Message, chatID, args string) string
+
+// Command is an instruction a contact can send instead of a question
+type Command struct {
+	Name string
+	// Usage describes the arguments, e.g. "<text>"
+	Usage       string
+	Description string
+	Handler     CommandHandler
+	// OwnerOnly commands are hidden from everyone but the bot's operators
+	// and every use is recorded in the audit log
+	OwnerOnly bool
+}
+
+var (
+	commands   = make(map[string]Command)
+	commandMux sync.RWMutex
+)
+
+// RegisterCommand adds a command to every bot, replacing one with the same
+// name
+func RegisterCommand(cmd Command) {
+	commandMux.Lock()
+	defer commandMux.Unlock()
+	commands[strings.ToLower(cmd.Name)] = cmd
+}
+
+// lookupCommand returns the command named name
+func lookupCommand(name string) (Command, bool) {
+	commandMux.RLock()
+	defer commandMux.RUnlock()
+	cmd, exists := commands[strings.ToLower(name)]
+	return cmd, exists
+}
+
+// listCommands returns the registered commands sorted by name
+func listCommands() []Command {
+	commandMux.RLock()
+	defer commandMux.RUnlock()
+
+	list := make([]Command, 0, len(commands))
+	for _, cmd := range commands {
+		list = append(list, cmd)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// command returns the command named name if the sender may use it
+func (b *Bot) command(msg *events.Message, name string) (Command, bool) {
+	cmd, exists := lookupCommand(name)
+	if !exists || (cmd.OwnerOnly && !b.isOwner(msg)) {
+		return Command{}, false
+	}
+	return cmd, true
+}
+
+// parseCommand splits "/name args" into its parts. ok is false for text
+// that isn't a command.
+func parseCommand(text string) (name, args string, ok bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, commandPrefix) || len(text) == len(commandPrefix) {
+		return "", "", false
+	}
+	fields := strings.SplitN(text[len(commandPrefix):], " ", 2)
+	name = fields[0]
+	if len(fields) > 1 {
+		args = strings.TrimSpace(fields[1])
+	}
+	return name, args, true
+}
+
+// handleCommand runs the command in msg, if it is one. It reports whether
+// the message was a known command and needs no answer from the model.
+func (b *Bot) handleCommand(msg *events.Message, chatID string) bool {
+	name, args, ok := parseCommand(messageText(msg))
+	if !ok {
+		return false
+	}
+	cmd, exists := b.command(msg, name)
+	if !exists {
+		return false
+	}
+
+	// Handlers may wait on the model, don't block the event loop
+	go func() {
+		reply := cmd.Handler(b, msg, chatID, args)
+		if cmd.OwnerOnly {
+			b.audit(msg, cmd.Name, args, reply)
+		}
+		if reply != "" {
+			if err := b.sendText(msg.Info.Chat, reply, nil); err != nil {
+				fmt.Printf("Error replying to /%s: %v\n", cmd.Name, err)
+			}
+		}
+	}()
+	return true
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "help",
+		Description: "Show this list",
+		Handler:     helpCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "reset",
+		Description: "Start a new conversation, keeping your settings",
+		Handler:     resetCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "forget",
+		Description: "Delete this chat's history, settings, reminders and consent",
+		Handler:     forgetCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "summary",
+		Description: "Summarize our conversation so far",
+		Handler:     summaryCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "model",
+		Usage:       "[name|auto]",
+		Description: "Show the available models or pick one for this chat",
+		Handler:     modelCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "persona",
+		Usage:       "[text|reset]",
+		Description: "Show or set how I should behave in this chat",
+		Handler:     personaCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "lang",
+		Usage:       "[language|auto]",
+		Description: "Show or set the language I answer in",
+		Handler:     langCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "think",
+		Description: "Show or hide a summary of my reasoning with each answer",
+		Handler:     thinkCommandHandler,
+	})
+}
+
+func helpCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	var sb strings.Builder
+	sb.WriteString("*Commands*")
+	owner := b.isOwner(msg)
+	for _, cmd := range listCommands() {
+		if cmd.OwnerOnly && !owner {
+			continue
+		}
+		sb.WriteString("\n" + commandPrefix + cmd.Name)
+		if cmd.Usage != "" {
+			sb.WriteString(" " + cmd.Usage)
+		}
+		sb.WriteString(" - " + cmd.Description)
+	}
+	return sb.String()
+}
+
+func resetCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	b.withConversation(chatID, func(conv *Conversation) {
+		conv.Messages = conv.Messages[:0]
+		conv.Summary = ""
+		conv.Truncated = false
+	})
+	return "Conversation cleared. Your settings are kept."
+}
+
+func forgetCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	// Reset in place, a running turn may still hold the conversation
+	b.withConversation(chatID, func(conv *Conversation) {
+		*conv = Conversation{Messages: make([]BotMessage, 0), LastActive: time.Now()}
+	})
+
+	store := b.accountManager.store
+	if err := store.DeleteChatDeferred(b.botID, chatID); err != nil {
+		fmt.Printf("Error deleting deferred replies of %s: %v\n", chatID, err)
+	}
+	if jobs, err := store.ListScheduled(b.botID, chatID); err == nil {
+		for _, job := range jobs {
+			if job.Kind != JobReminder {
+				continue
+			}
+			if _, err := store.CancelScheduled(b.botID, chatID, job.ID); err != nil {
+				fmt.Printf("Error cancelling reminder %d: %v\n", job.ID, err)
+			}
+		}
+	} else {
+		fmt.Printf("Error loading reminders of %s: %v\n", chatID, err)
+	}
+	// Consent is the contact's to give again, an owner's allowance stays
+	contact := msg.Info.Sender.ToNonAD().String()
+	if status, err := store.GetAccess(b.botID, contact); err == nil && status != "" && status != ContactAllowed {
+		if err := store.DeleteAccess(b.botID, contact); err != nil {
+			fmt.Printf("Error deleting consent of %s: %v\n", contact, err)
+		}
+	}
+	return "Done, I've forgotten this conversation, your settings, reminders and consent. Your usage is kept for the limits."
+}
+
+func summaryCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	b.mutex.RLock()
+	var sb strings.Builder
+	if conv, exists := b.conversations[chatID]; exists {
+		if conv.Summary != "" {
+			sb.WriteString("Earlier: " + conv.Summary + "\n")
+		}
+		for _, m := range conv.Messages {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", m.Role, m.Content))
+		}
+	}
+	b.mutex.RUnlock()
+
+	if sb.Len() == 0 {
+		return "There's nothing to summarize yet."
+	}
+	prompt := "Summarize the following conversation in a few short bullet points:\n\n" + sb.String()
+	summary, _, _, err := b.makeIndependentAIRequest(RequestSummary, prompt, b.requestTimeout(RequestSummary, len(prompt)))
+	if err != nil || strings.TrimSpace(summary) == "" {
+		return "I couldn't summarize the conversation right now. Please try again later."
+	}
+	return summary
+}
+
+func modelCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	endpoints := b.endpointConfigs()
+	if args == "" {
+		current := "auto"
+		b.withConversation(chatID, func(conv *Conversation) {
+			if conv.Model != "" {
+				current = conv.Model
+			}
+		})
+		var sb strings.Builder
+		sb.WriteString("Model for this chat: " + current + "\nAvailable:")
+		for _, ep := range endpoints {
+			sb.WriteString(fmt.Sprintf("\n- %s (%s)", ep.provider(), ep.modelName()))
+		}
+		return sb.String()
+	}
+
+	if strings.EqualFold(args, "auto") {
+		b.withConversation(chatID, func(conv *Conversation) { conv.Model = "" })
+		return "I'll pick the model automatically again."
+	}
+	for _, ep := range endpoints {
+		if strings.EqualFold(args, ep.provider()) || strings.EqualFold(args, ep.modelName()) {
+			b.withConversation(chatID, func(conv *Conversation) { conv.Model = args })
+			return "This chat now uses " + args + " when it is available."
+		}
+	}
+	return "Unknown model " + args + ". Send " + commandPrefix + "model to see the available ones."
+}
+
+func personaCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	switch {
+	case args == "":
+		persona := ""
+		b.withConversation(chatID, func(conv *Conversation) { persona = conv.Persona })
+		if persona == "" {
+			return "No persona set. Use " + commandPrefix + "persona <text> to set one."
+		}
+		return "Current persona: " + persona
+	case strings.EqualFold(args, "reset"):
+		b.withConversation(chatID, func(conv *Conversation) { conv.Persona = "" })
+		return "Persona removed."
+	}
+	b.withConversation(chatID, func(conv *Conversation) { conv.Persona = args })
+	return "Persona set."
+}
+
+func langCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	switch {
+	case args == "":
+		language := ""
+		b.withConversation(chatID, func(conv *Conversation) { language = conv.Language })
+		if language == "" {
+			return "I answer in the language you write in. Use " + commandPrefix + "lang <language> to fix one."
+		}
+		return "I answer in " + language + "."
+	case strings.EqualFold(args, "auto"):
+		b.withConversation(chatID, func(conv *Conversation) { conv.Language = "" })
+		return "I'll answer in the language you write in."
+	}
+	b.withConversation(chatID, func(conv *Conversation) { conv.Language = args })
+	return "I'll answer in " + args + " from now on."
+}
+
+func thinkCommandHandler(b *Bot, msg *events.Message, chatID, args string) string {
+	if b.toggleReasoning(chatID) {
+		return "I'll show a short summary of my reasoning with each answer. Send " + commandPrefix + "think again to hide it."
+	}
+	return "Reasoning is hidden again."
+}
+
+// withConversation runs fn on the chat's conversation under the bot's lock,
+// creating the conversation if needed
+func (b *Bot) withConversation(chatID string, fn func(conv *Conversation)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	conv, exists := b.conversations[chatID]
+	if !exists {
+		conv = &Conversation{Messages: make([]BotMessage, 0), LastActive: time.Now()}
+		b.conversations[chatID] = conv
+	}
+	fn(conv)
+}
+
+// chatMessages builds the request messages for a conversation: the chat's
+// settings and summary as a system message, then the history. Outside
+// business hours the away persona replaces the chat's. Callers must hold
+// the bot's lock.
+func (b *Bot) chatMessages(conv *Conversation) []map[string]string {
+	persona := conv.Persona
+	if away := b.awayPersona(); away != "" {
+		persona = away
+	}
+
+	var system []string
+	if persona != "" {
+		system = append(system, persona)
+	}
+	if conv.Language != "" {
+		system = append(system, "Always answer in "+conv.Language+".")
+	}
+	if conv.Summary != "" {
+		system = append(system, "Summary of the earlier conversation: "+conv.Summary)
+	}
+
+	messages := make([]map[string]string, 0, len(conv.Messages)+1)
+	if len(system) > 0 {
+		messages = append(messages, map[string]string{"role": "system", "content": strings.Join(system, "\n\n")})
+	}
+	for _, msg := range conv.Messages {
+		messages = append(messages, map[string]string{"role": msg.Role, "content": msg.Content})
+	}
+	return messages
+}
@@ -0,0 +1,36 @@
+package whatsapp
+
+import "testing"
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		text       string
+		name, args string
+		ok         bool
+	}{
+		{"/help", "help", "", true},
+		{"  /model  gpt-4o ", "model", "gpt-4o", true},
+		{"/persona You are a pirate", "persona", "You are a pirate", true},
+		{"/", "", "", false},
+		{"hello /help", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		name, args, ok := parseCommand(tt.text)
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("parseCommand(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.text, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestBuiltinCommands(t *testing.T) {
+	for _, name := range []string{"help", "reset", "forget", "summary", "model", "persona", "lang", "think"} {
+		if _, exists := lookupCommand(name); !exists {
+			t.Errorf("command %s is not registered", name)
+		}
+	}
+	if _, exists := lookupCommand("HELP"); !exists {
+		t.Error("command lookup is case sensitive")
+	}
+}
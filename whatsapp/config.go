@@ -0,0 +1,256 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	wtypes "go.mau.fi/whatsmeow/types"
+)
+
+// Config is the on-disk configuration shared by all bots. Settings under
+// "defaults" apply to every bot and can be overridden per bot under "bots".
+type Config struct {
+	// LLMMaxConcurrency caps in-flight model requests across all bots
+	LLMMaxConcurrency int `json:"llm_max_concurrency"`
+	// CircuitBreaker controls when a failing model server is skipped
+	CircuitBreaker BreakerConfig `json:"circuit_breaker"`
+	// HealthCheckInterval is how often model servers are probed
+	HealthCheckInterval Duration `json:"health_check_interval"`
+	// API is the HTTP API other systems send messages through
+	API      APIConfig                  `json:"api"`
+	Defaults json.RawMessage            `json:"defaults"`
+	Bots     map[string]json.RawMessage `json:"bots"`
+}
+
+// BotConfig holds the settings for a single bot instance
+type BotConfig struct {
+	// OwnerJIDs are the operators of this bot (phone numbers or full JIDs)
+	OwnerJIDs []string `json:"owner_jids"`
+	// VIPContacts are served ahead of normal chats
+	VIPContacts []string `json:"vip_contacts"`
+	// BulkContacts are served after everything else
+	BulkContacts []string `json:"bulk_contacts"`
+	// LaneWeights overrides the queue's scheduling weight per lane
+	// ("owner", "vip", "normal", "bulk")
+	LaneWeights map[string]int `json:"lane_weights"`
+	// MinWorkers and MaxWorkers bound how many messages the bot processes
+	// concurrently; the queue adapts within them based on model latency
+	MinWorkers int `json:"min_workers"`
+	MaxWorkers int `json:"max_workers"`
+	// LLMWeight is the bot's share of the global model capacity relative to
+	// the other bots
+	LLMWeight float64 `json:"llm_weight"`
+	// DebounceWindow collects messages a contact sends in quick succession
+	// into a single prompt; zero answers every message on its own
+	DebounceWindow Duration `json:"debounce_window"`
+	// SupersedePolicy decides what a new message does to a reply that is
+	// still being generated: SupersedeCancelMerge or SupersedeQueue
+	SupersedePolicy string `json:"supersede_policy"`
+	// RateLimits controls how fast contacts may message the bot
+	RateLimits RateLimitConfig `json:"rate_limits"`
+	// Quotas limit each contact's daily and monthly usage
+	Quotas QuotaConfig `json:"quotas"`
+	// UnavailableMessage is sent right away while the model server is
+	// known to be down
+	UnavailableMessage string `json:"unavailable_message"`
+	// Endpoints are the model servers the bot uses, in fallback order by
+	// tier. Empty means the local LM Studio server.
+	Endpoints []EndpointConfig `json:"endpoints"`
+	// EndpointStrategy picks between endpoints of the same tier:
+	// StrategyRoundRobin or StrategyLeastLatency
+	EndpointStrategy string `json:"endpoint_strategy"`
+	// Deferred answers questions later when the model is unavailable
+	Deferred DeferredConfig `json:"deferred_replies"`
+	// Continuation handles answers cut off at the token limit
+	Continuation ContinuationConfig `json:"continuation"`
+	// Attachments sends long code and tables as files
+	Attachments AttachmentConfig `json:"attachments"`
+	// Takeover pauses the bot in a chat an operator answers by hand
+	Takeover TakeoverConfig `json:"human_takeover"`
+	// Access decides which contacts are answered
+	Access AccessConfig `json:"access"`
+	// BusinessHours limits when the bot answers normally
+	BusinessHours BusinessHoursConfig `json:"business_hours"`
+	// Rules answer matching messages with canned replies
+	Rules RulesConfig `json:"rules"`
+	// Scheduler controls scheduled messages and reminders
+	Scheduler SchedulerConfig `json:"scheduler"`
+	// Campaigns paces broadcast campaigns
+	Campaigns CampaignConfig `json:"campaigns"`
+}
+
+// BreakerConfig is the JSON form of utils.BreakerConfig
+type BreakerConfig struct {
+	FailureThreshold int      `json:"failure_threshold"`
+	OpenTimeout      Duration `json:"open_timeout"`
+	HalfOpenProbes   int      `json:"half_open_probes"`
+}
+
+// RateLimit is a token bucket refilled at PerSecond tokens up to Burst. A
+// zero PerSecond disables the limit.
+type RateLimit struct {
+	PerSecond float64 `json:"per_second"`
+	Burst     int     `json:"burst"`
+}
+
+// RateLimitConfig holds the limits applied to incoming messages. A message
+// must pass the sender, chat and bot limits to be answered.
+type RateLimitConfig struct {
+	Sender RateLimit `json:"sender"`
+	Chat   RateLimit `json:"chat"`
+	Bot    RateLimit `json:"bot"`
+	// Tiers replace the sender limit for contacts in a priority lane
+	// ("owner", "vip")
+	Tiers map[string]RateLimit `json:"tiers"`
+	// NoticeCooldown is the minimum time between two "too fast" replies
+	// to the same contact
+	NoticeCooldown Duration `json:"notice_cooldown"`
+	// IdleTTL is how long an idle contact's limiter is kept in memory
+	IdleTTL Duration `json:"idle_ttl"`
+}
+
+// Duration is a time.Duration that is written as a string ("30s", "5m") in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// DefaultBotConfig returns the settings used when nothing is configured
+func DefaultBotConfig() *BotConfig {
+	return &BotConfig{
+		MinWorkers:      2,
+		MaxWorkers:      10,
+		LLMWeight:       1,
+		SupersedePolicy: SupersedeCancelMerge,
+		RateLimits: RateLimitConfig{
+			// Allow 1 request every 2 seconds
+			Sender: RateLimit{PerSecond: 0.5, Burst: 1},
+			Tiers: map[string]RateLimit{
+				"owner": {},
+				"vip":   {PerSecond: 2, Burst: 5},
+			},
+			NoticeCooldown: Duration(time.Minute),
+			IdleTTL:        Duration(defaultVisitorTTL),
+		},
+		Quotas: QuotaConfig{
+			ExhaustedMessage: defaultQuotaMessage,
+		},
+		UnavailableMessage: "I'm temporarily unavailable. Please try again in a few minutes.",
+		EndpointStrategy:   StrategyRoundRobin,
+		Deferred: DeferredConfig{
+			Expiry:  Duration(defaultDeferredExpiry),
+			Message: defaultDeferredMessage,
+		},
+		Continuation: ContinuationConfig{
+			MaxContinuations: 2,
+			MoreKeyword:      defaultMoreKeyword,
+			MoreHint:         defaultMoreHint,
+		},
+		Attachments: AttachmentConfig{
+			CodeLines:    30,
+			TableRows:    12,
+			TableColumns: 5,
+		},
+		Takeover: TakeoverConfig{
+			Enabled:  true,
+			Duration: Duration(defaultTakeoverDuration),
+		},
+		Access: AccessConfig{
+			Policy:           AccessOpen,
+			ConsentMessage:   defaultConsentMessage,
+			ConsentKeyword:   "yes",
+			ConsentedMessage: defaultConsentedMessage,
+			DeclineKeyword:   "stop",
+			DeclinedMessage:  defaultDeclinedMessage,
+		},
+		BusinessHours: BusinessHoursConfig{
+			AwayMode:    AwayMessage,
+			AwayMessage: defaultAwayMessage,
+		},
+		Scheduler: SchedulerConfig{
+			Reminders:    true,
+			MissedGrace:  Duration(defaultMissedGrace),
+			MaxReminders: defaultMaxReminders,
+		},
+		Campaigns: CampaignConfig{
+			Delay:       Duration(defaultCampaignDelay),
+			Jitter:      Duration(defaultCampaignJitter),
+			MaxPerHour:  defaultCampaignHourly,
+			ReplyWindow: Duration(defaultCampaignReplies),
+			MaxFailures: defaultCampaignFails,
+		},
+	}
+}
+
+// LoadConfig reads the configuration file. A missing file is not an error,
+// every bot then runs with the defaults.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{Bots: make(map[string]json.RawMessage)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	if cfg.Bots == nil {
+		cfg.Bots = make(map[string]json.RawMessage)
+	}
+	if err := cfg.API.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// BotConfig resolves the settings for a bot by layering its overrides on top
+// of the shared defaults
+func (c *Config) BotConfig(botID string) (*BotConfig, error) {
+	botCfg := DefaultBotConfig()
+
+	if len(c.Defaults) > 0 {
+		if err := json.Unmarshal(c.Defaults, botCfg); err != nil {
+			return nil, fmt.Errorf("invalid defaults: %v", err)
+		}
+	}
+	if raw, exists := c.Bots[botID]; exists {
+		if err := json.Unmarshal(raw, botCfg); err != nil {
+			return nil, fmt.Errorf("invalid config for %s: %v", botID, err)
+		}
+	}
+	return botCfg, nil
+}
+
+// containsJID reports whether jid is in list. Entries may be bare phone
+// numbers or full JIDs.
+func containsJID(list []string, jid wtypes.JID) bool {
+	full := jid.ToNonAD().String()
+	for _, entry := range list {
+		entry = strings.TrimPrefix(strings.TrimSpace(entry), "+")
+		if entry == jid.User || entry == full {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"whatsapp-gpt-bot/utils"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	// continuePrompt asks the model to pick up an answer that was cut off
+	continuePrompt     = "Continue exactly where your previous answer stopped. Do not repeat anything."
+	defaultMoreKeyword = "more"
+	defaultMoreHint    = "(Reply \"more\" for the rest)"
+)
+
+// ContinuationConfig controls what happens to answers cut off at
+// MAX_TOKENS
+type ContinuationConfig struct {
+	// MaxContinuations is how many continuations are requested
+	// automatically; an answer still cut off after that is sent as is and
+	// the contact can ask for the rest
+	MaxContinuations int `json:"max_continuations"`
+	// MoreKeyword is the reply that asks for the rest of an answer
+	MoreKeyword string `json:"more_keyword"`
+	// MoreHint is appended to an answer that was cut off
+	MoreHint string `json:"more_hint"`
+}
+
+// completion is one answer from the model
+type completion struct {
+	content string
+	// reasoning is the model's thinking, separated from the answer
+	reasoning string
+	// truncated is set when the model stopped at the token limit
+	truncated bool
+}
+
+// completeWithContinuation asks for an answer and, while it is cut off at
+// the token limit, for up to maxContinuations continuations. Each call gets
+// its own timeout. A failed continuation keeps what was generated so far.
+func (b *Bot) completeWithContinuation(ctx context.Context, kind, chatID string, messages []map[string]string, maxContinuations int, timeout time.Duration) (completion, error) {
+	result, err := b.postWithTimeout(ctx, kind, chatID, messages, timeout)
+	if err != nil {
+		return result, err
+	}
+
+	for i := 0; result.truncated && i < maxContinuations; i++ {
+		next, err := b.postWithTimeout(ctx, kind, chatID, continuationMessages(messages, result.content), timeout)
+		if err != nil {
+			break
+		}
+		result.content += next.content
+		result.reasoning = joinReasoning(result.reasoning, next.reasoning)
+		result.truncated = next.truncated
+	}
+	return result, nil
+}
+
+// postWithTimeout makes one model call with its own deadline
+func (b *Bot) postWithTimeout(ctx context.Context, kind, chatID string, messages []map[string]string, timeout time.Duration) (completion, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return b.postChatCompletion(ctx, kind, chatID, messages)
+}
+
+// continuationMessages extends a request with the partial answer and a
+// request to continue it
+func continuationMessages(messages []map[string]string, partial string) []map[string]string {
+	extended := make([]map[string]string, 0, len(messages)+2)
+	extended = append(extended, messages...)
+	return append(extended,
+		map[string]string{"role": "assistant", "content": partial},
+		map[string]string{"role": "user", "content": continuePrompt},
+	)
+}
+
+// withMoreHint adds the "more" hint to an answer that was cut off
+func (b *Bot) withMoreHint(c completion) string {
+	if !c.truncated {
+		return c.content
+	}
+	hint := b.getConfig().Continuation.MoreHint
+	if hint == "" {
+		hint = defaultMoreHint
+	}
+	return c.content + "…\n\n" + hint
+}
+
+// isMoreRequest reports whether text asks for the rest of the chat's last
+// answer, which must have been cut off
+func (b *Bot) isMoreRequest(chatID, text string) bool {
+	keyword := b.getConfig().Continuation.MoreKeyword
+	if keyword == "" {
+		keyword = defaultMoreKeyword
+	}
+	if !strings.EqualFold(strings.TrimSpace(text), keyword) {
+		return false
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	conv, exists := b.conversations[chatID]
+	return exists && conv.Truncated
+}
+
+// continueAnswer generates the rest of the chat's last answer. The
+// continuation is added to that answer in the history, so the exchange
+// reads as one reply.
+func (b *Bot) continueAnswer(ctx context.Context, msg *events.Message, chatID string) {
+	b.mutex.Lock()
+	conv := b.conversations[chatID]
+	conv.Truncated = false
+	messages := b.chatMessages(conv)
+	b.mutex.Unlock()
+	messages = append(messages, map[string]string{"role": "user", "content": continuePrompt})
+
+	release, err := b.accountManager.llmLimiter.Acquire(ctx, b.botID, chatID)
+	if err != nil {
+		return
+	}
+	timeout := b.requestTimeout(RequestChat, messageChars(messages))
+	start := time.Now()
+	result, err := b.completeWithContinuation(ctx, RequestChat, chatID, messages, b.getConfig().Continuation.MaxContinuations, timeout)
+	release()
+
+	latency := time.Since(start)
+	b.observeLatency(ctx, latency, err)
+	if ctx.Err() != nil {
+		// A newer message took over the chat
+		return
+	}
+	if err != nil {
+		utils.IncrementFailedRequest()
+		b.mutex.Lock()
+		conv.Truncated = true
+		b.mutex.Unlock()
+		b.sendAcknowledgment(msg.Info.Chat, "I couldn't get the rest of the answer right now. Please try \"more\" again.")
+		return
+	}
+
+	utils.RecordLMStudioMetrics(latency, estimateTokens(result.content))
+	b.recordUsage(msg, estimateTokens(result.content))
+	if b.inHumanMode(chatID) {
+		return
+	}
+
+	b.mutex.Lock()
+	for i := len(conv.Messages) - 1; i >= 0; i-- {
+		if conv.Messages[i].Role == "assistant" {
+			conv.Messages[i].Content += result.content
+			conv.Messages[i].Reasoning = joinReasoning(conv.Messages[i].Reasoning, result.reasoning)
+			break
+		}
+	}
+	conv.Truncated = result.truncated
+	b.mutex.Unlock()
+
+	b.sendReasoning(msg.Info.Chat, chatID, result.reasoning)
+	if err := b.sendReply(msg.Info.Chat, b.replyText(result), nil); err != nil {
+		fmt.Printf("Error sending message: %v\n", err)
+	}
+}
@@ -0,0 +1,75 @@
+package whatsapp
+
+import "testing"
+
+func TestWithMoreHint(t *testing.T) {
+	tests := []struct {
+		name string
+		hint string
+		c    completion
+		want string
+	}{
+		{"complete answer", "", completion{content: "All done."}, "All done."},
+		{"cut off", "", completion{content: "The first part", truncated: true}, "The first part…\n\n" + defaultMoreHint},
+		{"custom hint", "Say more!", completion{content: "Part", truncated: true}, "Part…\n\nSay more!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bot{config: &BotConfig{Continuation: ContinuationConfig{MoreHint: tt.hint}}}
+			if got := b.withMoreHint(tt.c); got != tt.want {
+				t.Errorf("withMoreHint = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMoreRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyword   string
+		text      string
+		truncated bool
+		want      bool
+	}{
+		{"after a cut off answer", "", " More ", true, true},
+		{"after a complete answer", "", "more", false, false},
+		{"other text", "", "more please", true, false},
+		{"custom keyword", "weiter", "Weiter", true, true},
+		{"default keyword replaced", "weiter", "more", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bot{
+				config:        &BotConfig{Continuation: ContinuationConfig{MoreKeyword: tt.keyword}},
+				conversations: map[string]*Conversation{"chat": {Truncated: tt.truncated}},
+			}
+			if got := b.isMoreRequest("chat", tt.text); got != tt.want {
+				t.Errorf("isMoreRequest(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+			if b.isMoreRequest("unknown", tt.text) {
+				t.Error("isMoreRequest is true for a chat without history")
+			}
+		})
+	}
+}
+
+func TestContinuationMessages(t *testing.T) {
+	messages := []map[string]string{{"role": "user", "content": "Tell me a story"}}
+	extended := continuationMessages(messages, "Once upon")
+	if len(messages) != 1 {
+		t.Fatal("continuationMessages changed the original request")
+	}
+	want := []struct{ role, content string }{
+		{"user", "Tell me a story"},
+		{"assistant", "Once upon"},
+		{"user", continuePrompt},
+	}
+	if len(extended) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(extended), len(want))
+	}
+	for i, w := range want {
+		if extended[i]["role"] != w.role || extended[i]["content"] != w.content {
+			t.Errorf("message %d = %v, want %s: %q", i, extended[i], w.role, w.content)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"whatsapp-gpt-bot/types"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	wtypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxDebounceExtensions limits how often a burst's window is extended while
+// the contact keeps typing, so a turn is never held back indefinitely
+const maxDebounceExtensions = 3
+
+// debouncer collects bursts of text messages from the same chat into a
+// single user turn
+type debouncer struct {
+	mutex   sync.Mutex
+	pending map[string]*pendingTurn
+	flush   func(msgs []*events.Message)
+	// seq numbers the timers, so one that fired while it was being
+	// restarted can tell it is stale
+	seq uint64
+}
+
+type pendingTurn struct {
+	messages   []*events.Message
+	timer      *time.Timer
+	timerSeq   uint64
+	window     time.Duration
+	extensions int
+}
+
+func newDebouncer(flush func(msgs []*events.Message)) *debouncer {
+	return &debouncer{
+		pending: make(map[string]*pendingTurn),
+		flush:   flush,
+	}
+}
+
+// Add appends msg to the chat's pending turn and restarts its window, which
+// follows the current configuration. It reports whether a new turn began.
+func (d *debouncer) Add(chatID string, msg *events.Message, window time.Duration) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	turn, exists := d.pending[chatID]
+	if !exists {
+		turn = &pendingTurn{}
+		d.pending[chatID] = turn
+	}
+	turn.window = window
+	turn.messages = append(turn.messages, msg)
+	d.restart(chatID, turn)
+	return !exists
+}
+
+// Extend restarts the window of a pending turn, used while the contact is
+// still typing
+func (d *debouncer) Extend(chatID string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	turn, exists := d.pending[chatID]
+	if !exists || turn.extensions >= maxDebounceExtensions {
+		return
+	}
+	turn.extensions++
+	d.restart(chatID, turn)
+}
+
+// restart replaces the turn's timer with one running for its window.
+// Callers must hold mutex.
+func (d *debouncer) restart(chatID string, turn *pendingTurn) {
+	if turn.timer != nil {
+		turn.timer.Stop()
+	}
+	d.seq++
+	seq := d.seq
+	turn.timerSeq = seq
+	turn.timer = time.AfterFunc(turn.window, func() { d.fire(chatID, seq) })
+}
+
+func (d *debouncer) fire(chatID string, seq uint64) {
+	d.mutex.Lock()
+	turn, exists := d.pending[chatID]
+	if !exists || turn.timerSeq != seq {
+		// The window was restarted after this timer fired
+		d.mutex.Unlock()
+		return
+	}
+	delete(d.pending, chatID)
+	d.mutex.Unlock()
+
+	if len(turn.messages) > 0 {
+		d.flush(turn.messages)
+	}
+}
+
+// mergeMessages combines a burst into one message carrying the text of all
+// of them, with the metadata of the last one
+func mergeMessages(msgs []*events.Message) *events.Message {
+	last := msgs[len(msgs)-1]
+	if len(msgs) == 1 {
+		return last
+	}
+
+	parts := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		if text := strings.TrimSpace(msg.Message.GetConversation()); text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	merged := *last
+	merged.Message = proto.Clone(last.Message).(*waE2E.Message)
+	merged.Message.Conversation = proto.String(strings.Join(parts, "\n"))
+	return &merged
+}
+
+// debounceTextMessage holds a text message back until the chat has been
+// quiet for the bot's debounce window
+func (b *Bot) debounceTextMessage(msg *events.Message, window time.Duration) {
+	if b.debouncer.Add(msg.Info.Chat.String(), msg, window) {
+		// WhatsApp only tells subscribers when the contact is typing
+		go func() {
+			if err := b.client.SubscribePresence(msg.Info.Chat); err != nil {
+				fmt.Printf("Error subscribing to presence of %s: %v\n", msg.Info.Chat, err)
+			}
+		}()
+	}
+}
+
+// flushDebounced enqueues a finished burst as a single text message
+func (b *Bot) flushDebounced(msgs []*events.Message) {
+	merged := mergeMessages(msgs)
+
+	if !b.checkRateLimits(merged) {
+		return
+	}
+
+	// Everything but the last message is answered as part of the merged turn
+	if len(msgs) > 1 {
+		ids := make([]wtypes.MessageID, 0, len(msgs)-1)
+		for _, msg := range msgs[:len(msgs)-1] {
+			ids = append(ids, msg.Info.ID)
+		}
+		go func() {
+			if err := b.client.MarkRead(ids, time.Now(), merged.Info.Chat, merged.Info.Sender); err != nil {
+				fmt.Printf("Error marking messages as read: %v\n", err)
+			}
+		}()
+	}
+
+	b.enqueueMessage(merged, types.TextMessage)
+}
+
+// handleConnected marks the bot as online when it debounces messages,
+// WhatsApp only sends typing notifications to clients that are
+func (b *Bot) handleConnected(evt interface{}) {
+	if _, ok := evt.(*events.Connected); !ok || b.getConfig().DebounceWindow <= 0 {
+		return
+	}
+	if err := b.client.SendPresence(wtypes.PresenceAvailable); err != nil {
+		fmt.Printf("Error sending presence: %v\n", err)
+	}
+}
+
+// handleChatPresence keeps a pending burst open while the contact is typing
+func (b *Bot) handleChatPresence(evt interface{}) {
+	if presence, ok := evt.(*events.ChatPresence); ok && presence.State == wtypes.ChatPresenceComposing {
+		b.debouncer.Extend(presence.Chat.String())
+	}
+}
@@ -0,0 +1,107 @@
+package whatsapp
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func textMessage(id, text string) *events.Message {
+	msg := &events.Message{Message: &waE2E.Message{Conversation: proto.String(text)}}
+	msg.Info.ID = id
+	return msg
+}
+
+func TestMergeMessages(t *testing.T) {
+	tests := []struct {
+		name  string
+		texts []string
+		want  string
+	}{
+		{"single", []string{"hello"}, "hello"},
+		{"burst", []string{"hi", "are you there?", "I have a question"}, "hi\nare you there?\nI have a question"},
+		{"blank parts dropped", []string{"hi", "  ", "again "}, "hi\nagain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msgs []*events.Message
+			for i, text := range tt.texts {
+				msgs = append(msgs, textMessage(string(rune('a'+i)), text))
+			}
+			merged := mergeMessages(msgs)
+			if got := merged.Message.GetConversation(); got != tt.want {
+				t.Errorf("merged text = %q, want %q", got, tt.want)
+			}
+			last := msgs[len(msgs)-1]
+			if merged.Info.ID != last.Info.ID {
+				t.Errorf("merged ID = %s, want the last message's %s", merged.Info.ID, last.Info.ID)
+			}
+			if len(msgs) > 1 && last.Message.GetConversation() != tt.texts[len(tt.texts)-1] {
+				t.Error("merging changed the last message")
+			}
+		})
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	const window = 30 * time.Millisecond
+	flushed := make(chan []*events.Message, 10)
+	d := newDebouncer(func(msgs []*events.Message) { flushed <- msgs })
+
+	if !d.Add("a", textMessage("1", "one"), window) {
+		t.Error("first message did not begin a turn")
+	}
+	if d.Add("a", textMessage("2", "two"), window) {
+		t.Error("second message began a new turn")
+	}
+	d.Add("b", textMessage("3", "other chat"), window)
+
+	got := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		select {
+		case msgs := <-flushed:
+			got[msgs[0].Info.ID] = len(msgs)
+		case <-time.After(time.Second):
+			t.Fatal("turn was not flushed")
+		}
+	}
+	if got["1"] != 2 || got["3"] != 1 {
+		t.Errorf("flushed turns %v, want 2 messages from chat a and 1 from chat b", got)
+	}
+	if !d.Add("a", textMessage("4", "later"), window) {
+		t.Error("message after a flush did not begin a new turn")
+	}
+	<-flushed
+}
+
+func TestDebouncerExtend(t *testing.T) {
+	const window = 40 * time.Millisecond
+	flushed := make(chan time.Time, 1)
+	d := newDebouncer(func(msgs []*events.Message) { flushed <- time.Now() })
+
+	start := time.Now()
+	d.Add("a", textMessage("1", "one"), window)
+	// Typing extends the window at most maxDebounceExtensions times, so
+	// the turn is flushed at 2.5 windows while the contact keeps typing
+	for i := 0; i < 10; i++ {
+		time.Sleep(window / 2)
+		d.Extend("a")
+	}
+
+	select {
+	case at := <-flushed:
+		held := at.Sub(start)
+		if held < 2*window {
+			t.Errorf("turn flushed after %v, before the extended window", held)
+		}
+		if held > 4*window {
+			t.Errorf("turn held for %v, extensions are not capped", held)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("turn was not flushed")
+	}
+	d.Extend("missing")
+}
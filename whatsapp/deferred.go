@@ -0,0 +1,196 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	wtypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultDeferredMessage = "I can't answer right now, but I'll reply here as soon as I'm back."
+	defaultDeferredExpiry  = 6 * time.Hour
+	deferredCheckInterval  = 30 * time.Second
+)
+
+// DeferredConfig controls answering questions later when the model is
+// unavailable, instead of asking the contact to try again
+type DeferredConfig struct {
+	Enabled bool `json:"enabled"`
+	// Expiry is how long a question is kept before it is abandoned
+	Expiry Duration `json:"expiry"`
+	// Message tells the contact the answer will come later
+	Message string `json:"message"`
+	// ExpiredMessage is sent when a question is abandoned; empty abandons
+	// it silently
+	ExpiredMessage string `json:"expired_message"`
+}
+
+// deferReply stores a question the model failed to answer so it can be
+// answered once the model is back. It reports false if deferred replies
+// are disabled or the question could not be stored.
+func (b *Bot) deferReply(msg *events.Message, chatID, prompt string) bool {
+	cfg := b.getConfig()
+	if !cfg.Deferred.Enabled {
+		return false
+	}
+
+	expiry := time.Duration(cfg.Deferred.Expiry)
+	if expiry <= 0 {
+		expiry = defaultDeferredExpiry
+	}
+	if err := b.storeDeferred(msg, chatID, prompt, time.Now().Add(expiry)); err != nil {
+		fmt.Printf("Error deferring reply in %s: %v\n", chatID, err)
+		return false
+	}
+
+	// The question is asked again when it is answered
+	b.dropUserMessage(chatID, prompt)
+
+	if b.rateLimiter.ShouldNotify("deferred|"+chatID, time.Duration(cfg.RateLimits.NoticeCooldown)) {
+		text := cfg.Deferred.Message
+		if text == "" {
+			text = defaultDeferredMessage
+		}
+		b.sendAcknowledgment(msg.Info.Chat, text)
+	}
+	return true
+}
+
+// runDeferredReplies periodically answers stored questions once the model
+// is reachable again, until the bot is closed
+func (b *Bot) runDeferredReplies() {
+	ticker := time.NewTicker(deferredCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.deliverDeferred()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// deliverDeferred answers pending questions oldest first and abandons the
+// expired ones. It stops at the first failure, the model is still down.
+func (b *Bot) deliverDeferred() {
+	pending, err := b.accountManager.store.ListDeferred(b.botID)
+	if err != nil {
+		fmt.Printf("Error loading deferred replies: %v\n", err)
+		return
+	}
+
+	// Outside business hours only the away persona may answer, expired
+	// questions are abandoned either way
+	hours := b.getConfig().BusinessHours
+	closed := hours.AwayMode != AwayAI && !hours.isOpen(time.Now())
+
+	for _, d := range pending {
+		if time.Now().After(d.ExpiresAt) {
+			b.abandonDeferred(d)
+			continue
+		}
+		if closed {
+			continue
+		}
+		// A human handles the chat, its question waits for the bot's return
+		if b.inHumanMode(d.Chat) {
+			continue
+		}
+		if !b.IsConnected() || !b.modelAvailable() {
+			return
+		}
+		if err := b.answerDeferred(d); err != nil {
+			fmt.Printf("Error answering deferred question in %s: %v\n", d.Chat, err)
+			return
+		}
+		if err := b.accountManager.store.DeleteDeferred(d.ID); err != nil {
+			fmt.Printf("Error removing deferred reply %d: %v\n", d.ID, err)
+		}
+	}
+}
+
+// answerDeferred generates the answer to a stored question and sends it as
+// a reply to the original message
+func (b *Bot) answerDeferred(d storage.DeferredReply) error {
+	chat, err := wtypes.ParseJID(d.Chat)
+	if err != nil {
+		return err
+	}
+	if err := b.initConversation(d.Chat); err != nil {
+		return err
+	}
+
+	// Wait for the chat's current turn instead of interrupting it
+	turn := b.turns.begin(d.Chat, d.Prompt, SupersedeQueue)
+	defer b.turns.finish(d.Chat, turn)
+
+	result, tokens, latency, err := b.makeAIRequest(turn.ctx, d.Prompt, d.Chat, b.requestTimeout(RequestChat, b.promptChars(d.Chat, d.Prompt)))
+	b.observeLatency(turn.ctx, latency, err)
+	if err != nil && !b.turns.isSuperseded(turn) {
+		b.dropUserMessage(d.Chat, d.Prompt)
+		return err
+	}
+	if !b.turns.commit(turn) {
+		// A newer message took over and answers this question with it
+		b.dropUserMessage(d.Chat, d.Prompt)
+		return nil
+	}
+
+	if !result.empty() {
+		b.mutex.Lock()
+		b.conversations[d.Chat].Messages = append(b.conversations[d.Chat].Messages, BotMessage{
+			Role:      "assistant",
+			Content:   result.content,
+			Reasoning: result.reasoning,
+			Time:      time.Now(),
+		})
+		b.conversations[d.Chat].Truncated = result.truncated
+		b.mutex.Unlock()
+	}
+
+	if err := b.accountManager.store.AddUsage(b.botID, d.Sender, time.Now(), 1, estimateTokens(d.Prompt)+tokens); err != nil {
+		fmt.Printf("Error recording usage of %s: %v\n", d.Sender, err)
+	}
+
+	// Quote the question, the conversation may have moved on since
+	return b.sendReply(chat, b.replyText(result), &waE2E.ContextInfo{
+		StanzaID:      proto.String(d.MessageID),
+		Participant:   proto.String(d.Sender),
+		QuotedMessage: &waE2E.Message{Conversation: proto.String(d.Prompt)},
+	})
+}
+
+// abandonDeferred drops an expired question, telling the contact if
+// configured
+func (b *Bot) abandonDeferred(d storage.DeferredReply) {
+	if err := b.accountManager.store.DeleteDeferred(d.ID); err != nil {
+		fmt.Printf("Error removing deferred reply %d: %v\n", d.ID, err)
+		return
+	}
+
+	text := b.getConfig().Deferred.ExpiredMessage
+	if text == "" || !b.IsConnected() {
+		return
+	}
+	chat, err := wtypes.ParseJID(d.Chat)
+	if err != nil {
+		return
+	}
+	b.sendAcknowledgment(chat, text)
+}
+
+// isDeferrable reports whether a failed request should be retried later,
+// cancelled turns are not failures of the model
+func isDeferrable(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,364 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"whatsapp-gpt-bot/utils"
+)
+
+// Endpoint selection strategies within a tier
+const (
+	StrategyRoundRobin   = "round_robin"
+	StrategyLeastLatency = "least_latency"
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	healthCheckTimeout         = 5 * time.Second
+)
+
+// EndpointConfig is one OpenAI-compatible model server a bot can use
+type EndpointConfig struct {
+	Name string `json:"name"`
+	// URL is the chat completions URL
+	URL    string `json:"url"`
+	Model  string `json:"model"`
+	APIKey string `json:"api_key"`
+	// Tier orders the fallback chain: all endpoints of the lowest tier are
+	// tried before any endpoint of the next one
+	Tier int `json:"tier"`
+	// Timeout bounds a single attempt so a hanging server fails over to the
+	// next one; zero lets the attempt use the whole request deadline
+	Timeout Duration `json:"timeout"`
+}
+
+// endpointState is what is known about a model server, shared by every bot
+// that uses it
+type endpointState struct {
+	url     string
+	breaker *utils.CircuitBreaker
+	mutex   sync.Mutex
+	healthy bool
+	checked time.Time
+	latency time.Duration
+}
+
+// EndpointStatus is a point-in-time view of a model server for the dashboard
+type EndpointStatus struct {
+	URL       string
+	Healthy   bool
+	LatencyMs float64
+	Breaker   string
+	CheckedAt time.Time
+}
+
+// endpointPool tracks the model servers used by all bots
+type endpointPool struct {
+	mutex     sync.Mutex
+	endpoints map[string]*endpointState
+	counters  map[string]uint64
+	newState  func(url string) *endpointState
+}
+
+func newEndpointPool(newState func(url string) *endpointState) *endpointPool {
+	return &endpointPool{
+		endpoints: make(map[string]*endpointState),
+		counters:  make(map[string]uint64),
+		newState:  newState,
+	}
+}
+
+// get returns the state of a server, registering it on first use
+func (p *endpointPool) get(url string) *endpointState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	state, exists := p.endpoints[url]
+	if !exists {
+		state = p.newState(url)
+		p.endpoints[url] = state
+	}
+	return state
+}
+
+func (p *endpointPool) all() []*endpointState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	states := make([]*endpointState, 0, len(p.endpoints))
+	for _, state := range p.endpoints {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].url < states[j].url })
+	return states
+}
+
+// next returns a per-bot counter used to rotate round-robin selection
+func (p *endpointPool) next(botID string) uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.counters[botID]++
+	return p.counters[botID]
+}
+
+func (s *endpointState) isHealthy() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.healthy
+}
+
+func (s *endpointState) averageLatency() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.latency
+}
+
+// observe records the outcome of a request to the server
+func (s *endpointState) observe(latency time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			s.healthy = false
+		}
+		return
+	}
+	s.healthy = true
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = time.Duration(float64(s.latency)*0.7 + float64(latency)*0.3)
+	}
+}
+
+func (s *endpointState) status() EndpointStatus {
+	breaker := s.breaker.State().String()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return EndpointStatus{
+		URL:       s.url,
+		Healthy:   s.healthy,
+		LatencyMs: float64(s.latency) / float64(time.Millisecond),
+		Breaker:   breaker,
+		CheckedAt: s.checked,
+	}
+}
+
+// checkHealth asks the server for its model list
+func (s *endpointState) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	healthy := false
+	modelsURL := strings.TrimSuffix(s.url, "/chat/completions") + "/models"
+	req, err := http.NewRequestWithContext(ctx, "GET", modelsURL, nil)
+	if err == nil {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			healthy = resp.StatusCode < http.StatusInternalServerError
+		}
+	}
+
+	s.mutex.Lock()
+	s.healthy = healthy
+	s.checked = time.Now()
+	s.mutex.Unlock()
+}
+
+// endpoint returns the shared state of a model server
+func (am *AccountManager) endpoint(url string) *endpointState {
+	return am.endpoints.get(url)
+}
+
+// EndpointStatuses returns the health of every known model server
+func (am *AccountManager) EndpointStatuses() []EndpointStatus {
+	states := am.endpoints.all()
+	statuses := make([]EndpointStatus, 0, len(states))
+	for _, state := range states {
+		statuses = append(statuses, state.status())
+	}
+	return statuses
+}
+
+// runHealthChecks periodically probes every known model server
+func (am *AccountManager) runHealthChecks(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, state := range am.endpoints.all() {
+				state.checkHealth()
+			}
+		case <-am.done:
+			return
+		}
+	}
+}
+
+// provider names the server in latency statistics
+func (ep EndpointConfig) provider() string {
+	if ep.Name != "" {
+		return ep.Name
+	}
+	return ep.URL
+}
+
+func (ep EndpointConfig) modelName() string {
+	if ep.Model != "" {
+		return ep.Model
+	}
+	return "local-model"
+}
+
+func (ep EndpointConfig) timeoutKey(kind string) timeoutKey {
+	return timeoutKey{Provider: ep.provider(), Model: ep.modelName(), Kind: kind}
+}
+
+// endpointConfigs returns the bot's configured endpoints, or the local
+// LM Studio server if there are none
+func (b *Bot) endpointConfigs() []EndpointConfig {
+	endpoints := append([]EndpointConfig(nil), b.getConfig().Endpoints...)
+	if len(endpoints) == 0 {
+		endpoints = []EndpointConfig{{Name: "lm-studio", URL: LM_STUDIO_URL, Model: "local-model"}}
+	}
+	return endpoints
+}
+
+// candidateEndpoints orders the bot's endpoints for one request: by tier,
+// then by the configured strategy within a tier. Endpoints known to be down
+// are moved to the end as a last resort. An endpoint matching preferred (a
+// name or model picked with /model) goes first while it is up.
+func (b *Bot) candidateEndpoints(preferred string) []EndpointConfig {
+	cfg := b.getConfig()
+	endpoints := b.endpointConfigs()
+
+	sort.SliceStable(endpoints, func(i, j int) bool { return endpoints[i].Tier < endpoints[j].Tier })
+
+	// Order each tier by strategy
+	for start := 0; start < len(endpoints); {
+		end := start
+		for end < len(endpoints) && endpoints[end].Tier == endpoints[start].Tier {
+			end++
+		}
+		tier := endpoints[start:end]
+
+		switch cfg.EndpointStrategy {
+		case StrategyLeastLatency:
+			sort.SliceStable(tier, func(i, j int) bool {
+				return b.accountManager.endpoint(tier[i].URL).averageLatency() <
+					b.accountManager.endpoint(tier[j].URL).averageLatency()
+			})
+		default:
+			if len(tier) > 1 {
+				offset := int(b.accountManager.endpoints.next(b.botID) % uint64(len(tier)))
+				rotated := append(append([]EndpointConfig(nil), tier[offset:]...), tier[:offset]...)
+				copy(tier, rotated)
+			}
+		}
+		start = end
+	}
+
+	healthy := make([]EndpointConfig, 0, len(endpoints))
+	var down []EndpointConfig
+	for _, ep := range endpoints {
+		state := b.accountManager.endpoint(ep.URL)
+		switch {
+		case !state.isHealthy():
+			down = append(down, ep)
+		case preferred != "" && (strings.EqualFold(preferred, ep.provider()) || strings.EqualFold(preferred, ep.modelName())):
+			healthy = append([]EndpointConfig{ep}, healthy...)
+		default:
+			healthy = append(healthy, ep)
+		}
+	}
+	return append(healthy, down...)
+}
+
+// modelAvailable reports whether any of the bot's endpoints is believed to
+// be able to answer
+func (b *Bot) modelAvailable() bool {
+	for _, ep := range b.candidateEndpoints("") {
+		state := b.accountManager.endpoint(ep.URL)
+		if state.isHealthy() && state.breaker.State() != utils.BreakerOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// postChatCompletion sends messages to the bot's model servers in failover
+// order and returns the first successful answer. Servers
+// whose circuit breaker is open are skipped. The latency of each attempt is
+// recorded under the request kind. chatID, if set, selects the chat's
+// preferred model.
+func (b *Bot) postChatCompletion(ctx context.Context, kind, chatID string, messages []map[string]string) (completion, error) {
+	inputChars := messageChars(messages)
+	preferred := ""
+	if chatID != "" {
+		b.mutex.RLock()
+		if conv, exists := b.conversations[chatID]; exists {
+			preferred = conv.Model
+		}
+		b.mutex.RUnlock()
+	}
+
+	var lastErr error
+	for _, ep := range b.candidateEndpoints(preferred) {
+		state := b.accountManager.endpoint(ep.URL)
+		done, err := state.breaker.Allow()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if ep.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, time.Duration(ep.Timeout))
+		}
+		start := time.Now()
+		result, err := b.doChatCompletion(attemptCtx, ep, messages)
+		cancel()
+
+		latency := time.Since(start)
+
+		// A request the caller gave up on says nothing about the server
+		outcome := err
+		if err != nil && ctx.Err() != nil {
+			outcome = context.Canceled
+		}
+		done(outcome)
+		state.observe(latency, outcome)
+		if timedOut := errors.Is(outcome, context.DeadlineExceeded); outcome == nil || timedOut {
+			b.timeouts.Observe(ep.timeoutKey(kind), inputChars, latency, timedOut)
+		}
+		if err == nil {
+			return result, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", ep.provider(), err)
+
+		// The request as a whole is out of time or was cancelled
+		if ctx.Err() != nil {
+			return completion{}, ctx.Err()
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no model endpoints configured")
+	}
+	return completion{}, lastErr
+}
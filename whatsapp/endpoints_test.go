@@ -0,0 +1,107 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"whatsapp-gpt-bot/utils"
+)
+
+// newEndpointTestBot returns a bot using the given endpoints, with fresh
+// endpoint states
+func newEndpointTestBot(t *testing.T, strategy string, endpoints []EndpointConfig) *Bot {
+	am := &AccountManager{}
+	am.endpoints = newEndpointPool(func(url string) *endpointState {
+		return &endpointState{url: url, breaker: utils.NewCircuitBreaker(t.Name()+"|"+url, utils.BreakerConfig{}), healthy: true}
+	})
+	return &Bot{
+		botID:          "1",
+		accountManager: am,
+		config:         &BotConfig{Endpoints: endpoints, EndpointStrategy: strategy},
+	}
+}
+
+var errTestEndpoint = errors.New("connection refused")
+
+func endpointNames(endpoints []EndpointConfig) string {
+	names := make([]string, len(endpoints))
+	for i, ep := range endpoints {
+		names[i] = ep.provider()
+	}
+	return strings.Join(names, ",")
+}
+
+func TestCandidateEndpoints(t *testing.T) {
+	endpoints := []EndpointConfig{
+		{Name: "backup", URL: "http://backup", Model: "small", Tier: 1},
+		{Name: "a", URL: "http://a", Model: "large"},
+		{Name: "b", URL: "http://b", Model: "large"},
+	}
+	tests := []struct {
+		name      string
+		latencies map[string]time.Duration
+		down      []string
+		preferred string
+		want      string
+	}{
+		{"by tier, then latency", map[string]time.Duration{"http://a": 200 * time.Millisecond, "http://b": 100 * time.Millisecond}, nil, "", "b,a,backup"},
+		{"down last", map[string]time.Duration{"http://a": 200 * time.Millisecond, "http://b": 100 * time.Millisecond}, []string{"http://b"}, "", "a,backup,b"},
+		{"lower tier down", nil, []string{"http://a", "http://b"}, "", "backup,a,b"},
+		{"preferred by name", map[string]time.Duration{"http://a": 200 * time.Millisecond, "http://b": 100 * time.Millisecond}, nil, "BACKUP", "backup,b,a"},
+		{"preferred by model", nil, nil, "small", "backup,a,b"},
+		{"preferred but down", nil, []string{"http://backup"}, "backup", "a,b,backup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newEndpointTestBot(t, StrategyLeastLatency, endpoints)
+			for url, latency := range tt.latencies {
+				b.accountManager.endpoint(url).observe(latency, nil)
+			}
+			for _, url := range tt.down {
+				b.accountManager.endpoint(url).observe(0, errTestEndpoint)
+			}
+			if got := endpointNames(b.candidateEndpoints(tt.preferred)); got != tt.want {
+				t.Errorf("candidateEndpoints = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCandidateEndpointsRoundRobin(t *testing.T) {
+	b := newEndpointTestBot(t, StrategyRoundRobin, []EndpointConfig{
+		{Name: "a", URL: "http://a"},
+		{Name: "b", URL: "http://b"},
+		{Name: "c", URL: "http://c"},
+		{Name: "backup", URL: "http://backup", Tier: 1},
+	})
+	want := []string{"b,c,a,backup", "c,a,b,backup", "a,b,c,backup", "b,c,a,backup"}
+	for i, w := range want {
+		if got := endpointNames(b.candidateEndpoints("")); got != w {
+			t.Errorf("request %d: candidateEndpoints = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestEndpointObserve(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		healthy bool
+	}{
+		{"success", nil, true},
+		{"failure", errTestEndpoint, false},
+		{"cancelled by the caller", context.Canceled, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &endpointState{healthy: true}
+			s.observe(100*time.Millisecond, tt.err)
+			if s.isHealthy() != tt.healthy {
+				t.Errorf("healthy = %v, want %v", s.isHealthy(), tt.healthy)
+			}
+		})
+	}
+}
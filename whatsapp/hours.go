@@ -0,0 +1,262 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Away modes decide how a bot answers outside business hours
+const (
+	// AwayMessage sends a fixed message once per contact and closed period
+	AwayMessage = "message"
+	// AwayAI keeps answering with the model, using the away persona
+	AwayAI = "ai"
+	// AwaySilent answers nothing and replies to the questions once open
+	AwaySilent = "silent"
+)
+
+const (
+	defaultAwayMessage = "Thanks for your message! We're closed right now and will get back to you during business hours."
+	// maxAwayQuestions caps the questions a chat can queue while closed
+	maxAwayQuestions = 20
+)
+
+// BusinessHoursConfig is a bot's weekly opening schedule. Outside of it the
+// bot answers according to AwayMode.
+type BusinessHoursConfig struct {
+	Enabled bool `json:"enabled"`
+	// Timezone is an IANA name like "Europe/Berlin", UTC if empty
+	Timezone string `json:"timezone"`
+	// Schedule maps a weekday ("mon" to "sun") to its opening ranges like
+	// "09:00-17:00"; a range ending before it starts runs past midnight.
+	// Days that are not listed are closed.
+	Schedule map[string][]string `json:"schedule"`
+	// Holidays are closed dates like "2026-12-25"
+	Holidays []string `json:"holidays"`
+	AwayMode string   `json:"away_mode"`
+	// AwayMessage is sent in AwayMessage mode
+	AwayMessage string `json:"away_message"`
+	// AwayPersona replaces the chat's persona in AwayAI mode
+	AwayPersona string `json:"away_persona"`
+}
+
+// openRange is one opening range, in minutes after midnight
+type openRange struct {
+	start, end int
+}
+
+var (
+	locations   = make(map[string]*time.Location)
+	locationMux sync.Mutex
+)
+
+// location returns the schedule's time zone, UTC if it is unknown
+func (c BusinessHoursConfig) location() *time.Location {
+	if c.Timezone == "" {
+		return time.UTC
+	}
+	locationMux.Lock()
+	defer locationMux.Unlock()
+
+	loc, exists := locations[c.Timezone]
+	if !exists {
+		var err error
+		loc, err = time.LoadLocation(c.Timezone)
+		if err != nil {
+			fmt.Printf("Unknown timezone %q, using UTC: %v\n", c.Timezone, err)
+			loc = time.UTC
+		}
+		locations[c.Timezone] = loc
+	}
+	return loc
+}
+
+// ranges returns the opening ranges starting on the given day, none on
+// holidays
+func (c BusinessHoursConfig) ranges(day time.Time) []openRange {
+	date := day.Format("2006-01-02")
+	for _, holiday := range c.Holidays {
+		if holiday == date {
+			return nil
+		}
+	}
+
+	weekday := strings.ToLower(day.Weekday().String()[:3])
+	var ranges []openRange
+	for key, specs := range c.Schedule {
+		if len(key) < 3 || !strings.EqualFold(key[:3], weekday) {
+			continue
+		}
+		for _, spec := range specs {
+			var h1, m1, h2, m2 int
+			if _, err := fmt.Sscanf(spec, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil {
+				fmt.Printf("Invalid business hours %q: %v\n", spec, err)
+				continue
+			}
+			r := openRange{start: h1*60 + m1, end: h2*60 + m2}
+			if r.end <= r.start {
+				r.end += 24 * 60
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// isOpen reports whether t falls within the schedule. Without business
+// hours the bot is always open.
+func (c BusinessHoursConfig) isOpen(t time.Time) bool {
+	if !c.Enabled {
+		return true
+	}
+	t = t.In(c.location())
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, r := range c.ranges(today) {
+		if minute >= r.start && minute < r.end {
+			return true
+		}
+	}
+	// Ranges of yesterday that run past midnight
+	for _, r := range c.ranges(today.AddDate(0, 0, -1)) {
+		if minute+24*60 < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpening returns when the schedule opens next after t, zero if it
+// never does within two weeks
+func (c BusinessHoursConfig) nextOpening(t time.Time) time.Time {
+	t = t.In(c.location())
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	var next time.Time
+	for d := 0; d < 14 && next.IsZero(); d++ {
+		day := today.AddDate(0, 0, d)
+		for _, r := range c.ranges(day) {
+			opening := time.Date(day.Year(), day.Month(), day.Day(), r.start/60, r.start%60, 0, 0, day.Location())
+			if opening.After(t) && (next.IsZero() || opening.Before(next)) {
+				next = opening
+			}
+		}
+	}
+	return next
+}
+
+// awayPersona returns the persona to answer with right now, empty unless
+// the bot is closed and answers with the model in AwayAI mode
+func (b *Bot) awayPersona() string {
+	cfg := b.getConfig().BusinessHours
+	if cfg.AwayMode != AwayAI || cfg.isOpen(time.Now()) {
+		return ""
+	}
+	return cfg.AwayPersona
+}
+
+// handleAfterHours answers a message that arrives while the bot is closed.
+// It reports whether the message has been dealt with and must not reach
+// the model; in AwayAI mode the model answers as usual.
+func (b *Bot) handleAfterHours(msg *events.Message, chatID string) bool {
+	cfg := b.getConfig().BusinessHours
+	now := time.Now()
+	if b.isOwner(msg) || cfg.isOpen(now) {
+		return false
+	}
+	opening := cfg.nextOpening(now)
+
+	switch cfg.AwayMode {
+	case AwayAI:
+		return false
+	case AwaySilent:
+		// Answered by the deferred replies loop once open again
+		text := messageText(msg)
+		if text == "" {
+			return true
+		}
+		// Queued questions are limited like the ones answered right away
+		if !b.checkRateLimits(msg) || !b.checkQuota(msg) {
+			return true
+		}
+		if count, err := b.accountManager.store.CountChatDeferred(b.botID, chatID); err != nil || count >= maxAwayQuestions {
+			if err != nil {
+				fmt.Printf("Error counting queued messages of %s: %v\n", chatID, err)
+			}
+			return true
+		}
+		expires := opening
+		if expires.IsZero() {
+			expires = now
+		}
+		expiry := time.Duration(b.getConfig().Deferred.Expiry)
+		if expiry <= 0 {
+			expiry = defaultDeferredExpiry
+		}
+		if err := b.storeDeferred(msg, chatID, text, expires.Add(expiry)); err != nil {
+			fmt.Printf("Error queueing message for business hours: %v\n", err)
+		}
+		return true
+	}
+
+	if b.awayNotices.shouldNotify(chatID, opening) {
+		text := cfg.AwayMessage
+		if text == "" {
+			text = defaultAwayMessage
+		}
+		b.sendAcknowledgment(msg.Info.Chat, text)
+	}
+	return true
+}
+
+// storeDeferred stores a question to be answered by the deferred replies
+// loop
+func (b *Bot) storeDeferred(msg *events.Message, chatID, prompt string, expires time.Time) error {
+	_, err := b.accountManager.store.AddDeferred(storage.DeferredReply{
+		BotID:     b.botID,
+		Chat:      chatID,
+		Sender:    msg.Info.Sender.ToNonAD().String(),
+		MessageID: msg.Info.ID,
+		Prompt:    prompt,
+		CreatedAt: time.Now(),
+		ExpiresAt: expires,
+	})
+	return err
+}
+
+// awayNotices remembers which chats got the away message in the current
+// closed period, keyed by the time that period ends
+type awayNotices struct {
+	mutex sync.Mutex
+	sent  map[string]time.Time
+}
+
+func newAwayNotices() *awayNotices {
+	return &awayNotices{sent: make(map[string]time.Time)}
+}
+
+// shouldNotify reports whether chatID still needs the away message for the
+// closed period ending at opening, and marks it as sent
+func (n *awayNotices) shouldNotify(chatID string, opening time.Time) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	for chat, until := range n.sent {
+		if !until.IsZero() && now.After(until) {
+			delete(n.sent, chat)
+		}
+	}
+	if until, exists := n.sent[chatID]; exists && until.Equal(opening) {
+		return false
+	}
+	n.sent[chatID] = opening
+	return true
+}
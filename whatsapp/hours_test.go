@@ -0,0 +1,121 @@
+package whatsapp
+
+import (
+	"testing"
+	"time"
+)
+
+var testHours = BusinessHoursConfig{
+	Enabled: true,
+	Schedule: map[string][]string{
+		"mon":    {"09:00-17:00"},
+		"tue":    {"09:00-17:00"},
+		"wed":    {"09:00-17:00"},
+		"thu":    {"09:00-17:00"},
+		"friday": {"09:00-17:00", "22:00-02:00"},
+		"Sat":    {"10:00-12:00"},
+	},
+	Holidays: []string{"2025-01-16"},
+}
+
+// at returns a time in January 2025; the 15th is a Wednesday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2025, 1, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestBusinessHoursIsOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"before opening", at(15, 8, 59), false},
+		{"opening", at(15, 9, 0), true},
+		{"before closing", at(15, 16, 59), true},
+		{"closing", at(15, 17, 0), false},
+		{"holiday", at(16, 12, 0), false},
+		{"evening range", at(17, 23, 0), true},
+		{"past midnight", at(18, 1, 30), true},
+		{"end past midnight", at(18, 2, 0), false},
+		{"saturday", at(18, 11, 0), true},
+		{"sunday", at(19, 12, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testHours.isOpen(tt.t); got != tt.want {
+				t.Errorf("isOpen(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+
+	disabled := testHours
+	disabled.Enabled = false
+	if !disabled.isOpen(at(19, 12, 0)) {
+		t.Error("disabled business hours are closed")
+	}
+}
+
+func TestBusinessHoursNextOpening(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{"same day", at(15, 8, 0), at(15, 9, 0)},
+		{"skips holiday", at(15, 18, 0), at(17, 9, 0)},
+		{"evening range", at(17, 18, 0), at(17, 22, 0)},
+		{"over the weekend", at(18, 12, 30), at(20, 9, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testHours.nextOpening(tt.t); !got.Equal(tt.want) {
+				t.Errorf("nextOpening(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+
+	closed := BusinessHoursConfig{Enabled: true}
+	if got := closed.nextOpening(at(15, 12, 0)); !got.IsZero() {
+		t.Errorf("nextOpening without a schedule = %v, want zero", got)
+	}
+}
+
+func TestBusinessHoursTimezone(t *testing.T) {
+	cfg := BusinessHoursConfig{
+		Enabled:  true,
+		Timezone: "Europe/Berlin",
+		Schedule: map[string][]string{"wed": {"09:00-17:00"}},
+	}
+	// 08:30 UTC is 09:30 in Berlin in winter
+	if !cfg.isOpen(at(15, 8, 30)) {
+		t.Error("closed at 09:30 Berlin time")
+	}
+	if cfg.isOpen(at(15, 16, 30)) {
+		t.Error("open at 17:30 Berlin time")
+	}
+	if got, want := cfg.nextOpening(at(15, 7, 0)), at(15, 8, 0); !got.Equal(want) {
+		t.Errorf("nextOpening = %v, want %v", got, want)
+	}
+}
+
+func TestAwayNotices(t *testing.T) {
+	n := newAwayNotices()
+	later := time.Now().Add(time.Hour)
+	tests := []struct {
+		chat    string
+		opening time.Time
+		want    bool
+	}{
+		{"a", later, true},
+		{"a", later, false},
+		{"b", later, true},
+		{"a", later.Add(24 * time.Hour), true},
+		{"c", time.Now().Add(-time.Minute), true},
+		{"c", time.Now().Add(time.Hour), true},
+	}
+	for i, tt := range tests {
+		if got := n.shouldNotify(tt.chat, tt.opening); got != tt.want {
+			t.Errorf("notice %d to %s: shouldNotify = %v, want %v", i+1, tt.chat, got, tt.want)
+		}
+	}
+}
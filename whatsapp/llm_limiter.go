@@ -0,0 +1,244 @@
+package whatsapp
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultLLMConcurrency = 4
+
+var (
+	llmInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "llm_requests_in_flight",
+		Help: "Number of LLM requests currently running across all bots",
+	})
+	llmWaiting = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "llm_requests_waiting",
+		Help: "Number of LLM requests waiting for a free slot",
+	})
+	llmWaitTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "llm_limiter_wait_seconds",
+		Help:    "Time LLM requests spend waiting for a free slot",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"bot_id"})
+)
+
+// LLMLimiter caps the number of in-flight LLM requests across all bots and
+// hands out free slots with weighted fair queuing. Each (bot, chat) pair is
+// a flow; a bot's weight is split evenly between its active chats, so a bot
+// with weight 2 gets twice the share of a bot with weight 1 and one chatty
+// contact can't starve the others.
+type LLMLimiter struct {
+	mutex       sync.Mutex
+	capacity    int
+	inFlight    int
+	virtualTime float64
+	lastFinish  map[string]float64
+	flowActive  map[string]int
+	botFlows    map[string]map[string]bool
+	weights     map[string]float64
+	waiting     llmWaitQueue
+	seq         uint64
+}
+
+type llmWaiter struct {
+	flow  string
+	botID string
+	start float64
+	tag   float64
+	seq   uint64
+	ready chan struct{}
+	index int
+}
+
+// NewLLMLimiter creates a limiter allowing capacity concurrent requests
+func NewLLMLimiter(capacity int) *LLMLimiter {
+	if capacity < 1 {
+		capacity = defaultLLMConcurrency
+	}
+	return &LLMLimiter{
+		capacity:   capacity,
+		lastFinish: make(map[string]float64),
+		flowActive: make(map[string]int),
+		botFlows:   make(map[string]map[string]bool),
+		weights:    make(map[string]float64),
+	}
+}
+
+// SetWeight sets a bot's share of the capacity relative to other bots
+func (l *LLMLimiter) SetWeight(botID string, weight float64) {
+	if weight <= 0 {
+		weight = 1
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.weights[botID] = weight
+}
+
+// SetCapacity changes the maximum number of in-flight requests
+func (l *LLMLimiter) SetCapacity(capacity int) {
+	if capacity < 1 {
+		capacity = defaultLLMConcurrency
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.capacity = capacity
+	l.dispatch()
+}
+
+// Stats returns the number of running and waiting requests
+func (l *LLMLimiter) Stats() (inFlight, waiting, capacity int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.inFlight, len(l.waiting), l.capacity
+}
+
+// Acquire blocks until the request may run and returns a function that
+// must be called once it has finished
+func (l *LLMLimiter) Acquire(ctx context.Context, botID, chatID string) (func(), error) {
+	start := time.Now()
+	flow := botID + "|" + chatID
+
+	l.mutex.Lock()
+	l.join(botID, flow)
+
+	// Tag the request with its virtual finish time. A flow that was idle
+	// starts at the current virtual time instead of catching up.
+	weight := l.weight(botID) / float64(len(l.botFlows[botID]))
+	w := &llmWaiter{flow: flow, botID: botID, ready: make(chan struct{})}
+	w.start = l.lastFinish[flow]
+	if w.start < l.virtualTime {
+		w.start = l.virtualTime
+	}
+	w.tag = w.start + 1/weight
+	l.lastFinish[flow] = w.tag
+	l.seq++
+	w.seq = l.seq
+
+	heap.Push(&l.waiting, w)
+	llmWaiting.Inc()
+	l.dispatch()
+	l.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		l.mutex.Lock()
+		if w.index >= 0 {
+			// Still queued, give up our place
+			heap.Remove(&l.waiting, w.index)
+			llmWaiting.Dec()
+			l.leave(botID, flow)
+			l.mutex.Unlock()
+			return nil, ctx.Err()
+		}
+		l.mutex.Unlock()
+		// The slot was granted while we were cancelled, hand it back
+		<-w.ready
+		l.release(botID, flow)
+		return nil, ctx.Err()
+	}
+
+	llmWaitTime.WithLabelValues(botID).Observe(time.Since(start).Seconds())
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(botID, flow) })
+	}, nil
+}
+
+func (l *LLMLimiter) release(botID, flow string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.inFlight--
+	llmInFlight.Dec()
+	l.leave(botID, flow)
+	l.dispatch()
+}
+
+// dispatch grants free slots to the waiters with the smallest finish tags.
+// Callers must hold mutex.
+func (l *LLMLimiter) dispatch() {
+	for l.inFlight < l.capacity && len(l.waiting) > 0 {
+		w := heap.Pop(&l.waiting).(*llmWaiter)
+		llmWaiting.Dec()
+		if w.start > l.virtualTime {
+			l.virtualTime = w.start
+		}
+		l.inFlight++
+		llmInFlight.Inc()
+		close(w.ready)
+	}
+}
+
+func (l *LLMLimiter) weight(botID string) float64 {
+	if weight, exists := l.weights[botID]; exists {
+		return weight
+	}
+	return 1
+}
+
+// join and leave track how many requests each flow has queued or running.
+// Callers must hold mutex.
+func (l *LLMLimiter) join(botID, flow string) {
+	l.flowActive[flow]++
+	if l.botFlows[botID] == nil {
+		l.botFlows[botID] = make(map[string]bool)
+	}
+	l.botFlows[botID][flow] = true
+}
+
+func (l *LLMLimiter) leave(botID, flow string) {
+	l.flowActive[flow]--
+	if l.flowActive[flow] > 0 {
+		return
+	}
+	delete(l.flowActive, flow)
+	delete(l.botFlows[botID], flow)
+	if len(l.botFlows[botID]) == 0 {
+		delete(l.botFlows, botID)
+	}
+	// Idle flows restart from the virtual clock. Their last request was
+	// dispatched, so the tag is at most one request ahead of it.
+	delete(l.lastFinish, flow)
+}
+
+// llmWaitQueue is a min-heap of waiters ordered by finish tag
+type llmWaitQueue []*llmWaiter
+
+func (q llmWaitQueue) Len() int { return len(q) }
+
+func (q llmWaitQueue) Less(i, j int) bool {
+	if q[i].tag == q[j].tag {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].tag < q[j].tag
+}
+
+func (q llmWaitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *llmWaitQueue) Push(x interface{}) {
+	w := x.(*llmWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *llmWaitQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
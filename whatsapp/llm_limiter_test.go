@@ -0,0 +1,132 @@
+package whatsapp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+	}
+}
+
+func TestLLMLimiterFairness(t *testing.T) {
+	type requests struct {
+		bot, chat string
+		n         int
+	}
+	tests := []struct {
+		name     string
+		weights  map[string]float64
+		requests []requests
+		grants   int
+		want     map[string]int
+	}{
+		{
+			"equal bots alternate",
+			nil,
+			[]requests{{"a", "1", 4}, {"b", "1", 4}},
+			4,
+			map[string]int{"a|1": 2, "b|1": 2},
+		},
+		{
+			"weighted bots",
+			map[string]float64{"a": 2, "b": 1},
+			[]requests{{"a", "1", 6}, {"b", "1", 6}},
+			6,
+			map[string]int{"a|1": 4, "b|1": 2},
+		},
+		{
+			"busy chat doesn't starve another",
+			nil,
+			[]requests{{"a", "1", 6}, {"a", "2", 2}},
+			6,
+			map[string]int{"a|1": 4, "a|2": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLLMLimiter(1)
+			for bot, weight := range tt.weights {
+				l.SetWeight(bot, weight)
+			}
+			// Hold the only slot while the requests queue up in order
+			hold, err := l.Acquire(context.Background(), "hold", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mutex sync.Mutex
+			var order []string
+			var wg sync.WaitGroup
+			queued := 0
+			for _, r := range tt.requests {
+				for i := 0; i < r.n; i++ {
+					wg.Add(1)
+					go func(bot, chat string) {
+						defer wg.Done()
+						release, err := l.Acquire(context.Background(), bot, chat)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						mutex.Lock()
+						order = append(order, bot+"|"+chat)
+						mutex.Unlock()
+						release()
+					}(r.bot, r.chat)
+					queued++
+					waitFor(t, func() bool { _, waiting, _ := l.Stats(); return waiting == queued })
+				}
+			}
+			hold()
+			wg.Wait()
+
+			got := make(map[string]int)
+			for _, flow := range order[:tt.grants] {
+				got[flow]++
+			}
+			for flow, n := range tt.want {
+				if got[flow] != n {
+					t.Errorf("first %d grants %v, want %v", tt.grants, order[:tt.grants], tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLLMLimiterCancel(t *testing.T) {
+	l := NewLLMLimiter(1)
+	hold, _ := l.Acquire(context.Background(), "a", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := l.Acquire(ctx, "b", "1")
+		errs <- err
+	}()
+	waitFor(t, func() bool { _, waiting, _ := l.Stats(); return waiting == 1 })
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("Acquire = %v, want context.Canceled", err)
+	}
+
+	hold()
+	inFlight, waiting, _ := l.Stats()
+	if inFlight != 0 || waiting != 0 {
+		t.Errorf("in flight %d, waiting %d after cancel, want 0 and 0", inFlight, waiting)
+	}
+	if release, err := l.Acquire(context.Background(), "c", "1"); err != nil {
+		t.Errorf("Acquire after cancel: %v", err)
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,106 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+	"whatsapp-gpt-bot/types"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const defaultQuotaMessage = "You've reached your usage limit for now. Please try again later."
+
+// QuotaConfig limits how much a single contact may use the bot. A zero
+// limit is unlimited. Owners are never limited.
+type QuotaConfig struct {
+	DailyMessages   int `json:"daily_messages"`
+	DailyTokens     int `json:"daily_tokens"`
+	MonthlyMessages int `json:"monthly_messages"`
+	MonthlyTokens   int `json:"monthly_tokens"`
+	// ExhaustedMessage is sent instead of an answer once a quota is used up
+	ExhaustedMessage string `json:"exhausted_message"`
+}
+
+func (q QuotaConfig) enabled() bool {
+	return q.DailyMessages > 0 || q.DailyTokens > 0 || q.MonthlyMessages > 0 || q.MonthlyTokens > 0
+}
+
+// exceeded reports whether usage has reached any of the limits
+func (q QuotaConfig) exceeded(usage storage.Usage) bool {
+	return (q.DailyMessages > 0 && usage.DailyMessages >= q.DailyMessages) ||
+		(q.DailyTokens > 0 && usage.DailyTokens >= q.DailyTokens) ||
+		(q.MonthlyMessages > 0 && usage.MonthlyMessages >= q.MonthlyMessages) ||
+		(q.MonthlyTokens > 0 && usage.MonthlyTokens >= q.MonthlyTokens)
+}
+
+// checkQuota reports whether the sender may get another model answer. When
+// the quota is used up the sender is told so, at most once per notice
+// cooldown.
+func (b *Bot) checkQuota(msg *events.Message) bool {
+	cfg := b.getConfig()
+	if !cfg.Quotas.enabled() || b.messagePriority(msg) == types.PriorityOwner {
+		return true
+	}
+
+	contact := msg.Info.Sender.ToNonAD().String()
+	usage, err := b.accountManager.store.GetUsage(b.botID, contact, time.Now())
+	if err != nil {
+		// Don't punish contacts for a database problem
+		fmt.Printf("Error reading usage of %s: %v\n", contact, err)
+		return true
+	}
+	if !cfg.Quotas.exceeded(usage) {
+		return true
+	}
+
+	if b.rateLimiter.ShouldNotify("quota|"+contact, time.Duration(cfg.RateLimits.NoticeCooldown)) {
+		text := cfg.Quotas.ExhaustedMessage
+		if text == "" {
+			text = defaultQuotaMessage
+		}
+		b.sendAcknowledgment(msg.Info.Chat, text)
+	}
+	return false
+}
+
+// recordUsage adds one answered message and its tokens to the sender's usage
+func (b *Bot) recordUsage(msg *events.Message, tokens int) {
+	contact := msg.Info.Sender.ToNonAD().String()
+	if err := b.accountManager.store.AddUsage(b.botID, contact, time.Now(), 1, tokens); err != nil {
+		fmt.Printf("Error recording usage of %s: %v\n", contact, err)
+	}
+}
+
+// estimateTokens approximates the token count of text the same way the
+// LM Studio metrics do, by counting words
+func estimateTokens(text string) int {
+	return len(strings.Split(text, " "))
+}
+
+// normalizeContact turns a phone number into a user JID, full JIDs are
+// returned unchanged
+func normalizeContact(contact string) string {
+	contact = strings.TrimPrefix(strings.TrimSpace(contact), "+")
+	if !strings.Contains(contact, "@") {
+		contact += "@s.whatsapp.net"
+	}
+	return contact
+}
+
+// Usage returns the usage of every contact of a bot this month
+func (am *AccountManager) Usage(botID string) ([]storage.Usage, error) {
+	return am.store.ListUsage(botID, time.Now())
+}
+
+// ContactUsage returns one contact's usage of a bot
+func (am *AccountManager) ContactUsage(botID, contact string) (storage.Usage, error) {
+	return am.store.GetUsage(botID, normalizeContact(contact), time.Now())
+}
+
+// ResetUsage clears a contact's usage so their quota starts over
+func (am *AccountManager) ResetUsage(botID, contact string) error {
+	return am.store.ResetUsage(botID, normalizeContact(contact))
+}
@@ -4,56 +4,217 @@ import (
 	"sync"
 	"time"
 
+	"go.mau.fi/whatsmeow/types/events"
 	"golang.org/x/time/rate"
 )
 
+// defaultVisitorTTL is how long an idle visitor's limiter is kept
+const defaultVisitorTTL = 30 * time.Minute
+
+// visitor is the rate limiting state of one user, chat or bot
+type visitor struct {
+	limiter    *rate.Limiter
+	lastSeen   time.Time
+	lastNotice time.Time
+}
+
 // RateLimiter holds the rate limiters for each user
 type RateLimiter struct {
-	visitors map[string]*rate.Limiter
+	visitors map[string]*visitor
 	mutex    sync.Mutex
 	limit    rate.Limit
 	burst    int
+	ttl      time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewRateLimiter creates a new rate limiter
 func NewRateLimiter(limit rate.Limit, burst int) *RateLimiter {
 	return &RateLimiter{
-		visitors: make(map[string]*rate.Limiter),
+		visitors: make(map[string]*visitor),
 		limit:    limit,
 		burst:    burst,
+		ttl:      defaultVisitorTTL,
+		stop:     make(chan struct{}),
 	}
 }
 
 // Allow checks if a user is allowed to make a request
 func (rl *RateLimiter) Allow(userID string) bool {
+	return rl.AllowWith(userID, rl.limit, rl.burst)
+}
+
+// AllowWith checks a request against a specific limit, used for contacts
+// that get a different tier than the limiter's default
+func (rl *RateLimiter) AllowWith(userID string, limit rate.Limit, burst int) bool {
+	r := rl.ReserveWith(userID, limit, burst)
+	if r == nil {
+		return true
+	}
+	if !r.OK() || r.Delay() > 0 {
+		r.Cancel()
+		return false
+	}
+	return true
+}
+
+// ReserveWith takes a token for userID without rejecting. The caller must
+// Cancel the reservation if it has a delay and the request is dropped. A
+// nil reservation means the limit is disabled.
+func (rl *RateLimiter) ReserveWith(userID string, limit rate.Limit, burst int) *rate.Reservation {
+	if limit <= 0 {
+		return nil
+	}
+
 	rl.mutex.Lock()
 	defer rl.mutex.Unlock()
 
-	limiter, exists := rl.visitors[userID]
-	if !exists {
-		limiter = rate.NewLimiter(rl.limit, rl.burst)
-		rl.visitors[userID] = limiter
+	v := rl.visitor(userID, limit, burst)
+	return v.limiter.Reserve()
+}
+
+// ShouldNotify reports whether a rejected user should be told about the
+// limit, at most once per cooldown
+func (rl *RateLimiter) ShouldNotify(userID string, cooldown time.Duration) bool {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	v := rl.visitor(userID, rl.limit, rl.burst)
+	if time.Since(v.lastNotice) < cooldown {
+		return false
+	}
+	v.lastNotice = time.Now()
+	return true
+}
+
+// SetLimit changes the default limit, existing visitors pick it up on their
+// next request
+func (rl *RateLimiter) SetLimit(limit rate.Limit, burst int) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.limit = limit
+	rl.burst = burst
+}
+
+// SetTTL changes how long idle visitors are kept
+func (rl *RateLimiter) SetTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultVisitorTTL
 	}
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.ttl = ttl
+}
 
-	return limiter.Allow()
+// Len returns the number of tracked visitors
+func (rl *RateLimiter) Len() int {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return len(rl.visitors)
+}
+
+// visitor returns the state for userID, creating it or updating its limit
+// as needed. Callers must hold mutex.
+func (rl *RateLimiter) visitor(userID string, limit rate.Limit, burst int) *visitor {
+	v, exists := rl.visitors[userID]
+	if !exists {
+		v = &visitor{limiter: rate.NewLimiter(limit, burst)}
+		rl.visitors[userID] = v
+	} else if v.limiter.Limit() != limit || v.limiter.Burst() != burst {
+		v.limiter.SetLimit(limit)
+		v.limiter.SetBurst(burst)
+	}
+	v.lastSeen = time.Now()
+	return v
 }
 
 // StartCleanup starts a goroutine to clean up old rate limiters
 func (rl *RateLimiter) StartCleanup() {
 	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+
 		for {
-			time.Sleep(1 * time.Minute)
-			rl.cleanupStaleVisitors()
+			select {
+			case <-ticker.C:
+				rl.cleanupStaleVisitors()
+			case <-rl.stop:
+				return
+			}
 		}
 	}()
 }
 
+// Stop ends the cleanup goroutine
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() { close(rl.stop) })
+}
+
 // cleanupStaleVisitors removes rate limiters for users who haven't been active
 func (rl *RateLimiter) cleanupStaleVisitors() {
 	rl.mutex.Lock()
 	defer rl.mutex.Unlock()
 
-	// In a real-world scenario, you'd want to track the last access time
-	// for each user and remove them if they haven't been active for a while.
-	// For this example, we'll just keep all visitors.
-}
\ No newline at end of file
+	for id, v := range rl.visitors {
+		// A visitor still serving a notice cooldown must be kept, or the
+		// next rejection would notify again
+		if time.Since(v.lastSeen) > rl.ttl && time.Since(v.lastNotice) > rl.ttl {
+			delete(rl.visitors, id)
+		}
+	}
+}
+
+// checkRateLimits applies the bot's sender, chat and bot limits to an
+// incoming message. Rejected contacts are told to slow down at most once per
+// cooldown window.
+func (b *Bot) checkRateLimits(msg *events.Message) bool {
+	cfg := b.getConfig().RateLimits
+	sender := msg.Info.Sender.ToNonAD().String()
+
+	senderLimit := cfg.Sender
+	if tier, exists := cfg.Tiers[b.messagePriority(msg).String()]; exists {
+		senderLimit = tier
+	}
+
+	reservations := []*rate.Reservation{
+		b.rateLimiter.ReserveWith(sender, rate.Limit(senderLimit.PerSecond), senderLimit.Burst),
+		b.chatLimiter.ReserveWith(msg.Info.Chat.String(), rate.Limit(cfg.Chat.PerSecond), cfg.Chat.Burst),
+		b.botLimiter.ReserveWith(b.botID, rate.Limit(cfg.Bot.PerSecond), cfg.Bot.Burst),
+	}
+
+	allowed := true
+	for _, r := range reservations {
+		if r != nil && (!r.OK() || r.Delay() > 0) {
+			allowed = false
+		}
+	}
+	if allowed {
+		return true
+	}
+
+	// Hand back the tokens of the limits that did pass
+	for _, r := range reservations {
+		if r != nil {
+			r.Cancel()
+		}
+	}
+
+	if b.rateLimiter.ShouldNotify(sender, time.Duration(cfg.NoticeCooldown)) {
+		b.sendAcknowledgment(msg.Info.Chat, "You are sending messages too fast. Please wait a moment.")
+	}
+	return false
+}
+
+// applyRateLimitConfig updates the limiters after the configuration changed
+func (b *Bot) applyRateLimitConfig() {
+	cfg := b.getConfig().RateLimits
+	ttl := time.Duration(cfg.IdleTTL)
+
+	b.rateLimiter.SetLimit(rate.Limit(cfg.Sender.PerSecond), cfg.Sender.Burst)
+	b.rateLimiter.SetTTL(ttl)
+	b.chatLimiter.SetLimit(rate.Limit(cfg.Chat.PerSecond), cfg.Chat.Burst)
+	b.chatLimiter.SetTTL(ttl)
+	b.botLimiter.SetLimit(rate.Limit(cfg.Bot.PerSecond), cfg.Bot.Burst)
+	b.botLimiter.SetTTL(ttl)
+}
@@ -0,0 +1,99 @@
+package whatsapp
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimiterAllowWith(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    rate.Limit
+		burst    int
+		requests int
+		allowed  int
+	}{
+		{"disabled", 0, 0, 10, 10},
+		{"burst", rate.Every(time.Hour), 3, 5, 3},
+		{"higher tier", rate.Every(time.Hour), 5, 5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiter(rate.Every(time.Hour), 1)
+			allowed := 0
+			for i := 0; i < tt.requests; i++ {
+				if rl.AllowWith("user", tt.limit, tt.burst) {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d requests, want %d", allowed, tt.requests, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterTierChange(t *testing.T) {
+	rl := NewRateLimiter(rate.Every(time.Hour), 1)
+	if !rl.Allow("user") || rl.Allow("user") {
+		t.Fatal("default limit did not allow exactly one request")
+	}
+	// A tier change applies to the visitor's existing limiter
+	rl.AllowWith("user", rate.Limit(1000), 1)
+	time.Sleep(5 * time.Millisecond)
+	if !rl.AllowWith("user", rate.Limit(1000), 1) {
+		t.Error("request was rejected after moving to a faster tier")
+	}
+	if rl.Len() != 1 {
+		t.Errorf("tracking %d visitors, want 1", rl.Len())
+	}
+}
+
+func TestRateLimiterShouldNotify(t *testing.T) {
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+		wait     time.Duration
+		want     []bool
+	}{
+		{"once per cooldown", time.Hour, 0, []bool{true, false, false}},
+		{"after cooldown", 10 * time.Millisecond, 20 * time.Millisecond, []bool{true, true, true}},
+		{"no cooldown", 0, 0, []bool{true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiter(1, 1)
+			for i, want := range tt.want {
+				if got := rl.ShouldNotify("user", tt.cooldown); got != want {
+					t.Errorf("notice %d: ShouldNotify = %v, want %v", i+1, got, want)
+				}
+				time.Sleep(tt.wait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	rl := NewRateLimiter(1, 1)
+	rl.SetTTL(10 * time.Millisecond)
+	rl.Allow("idle")
+	rl.ShouldNotify("notified", time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	rl.Allow("active")
+
+	rl.mutex.Lock()
+	// A recent notice keeps a visitor for the whole ttl after it
+	rl.visitors["notified"].lastNotice = time.Now()
+	rl.mutex.Unlock()
+
+	rl.cleanupStaleVisitors()
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	for id, want := range map[string]bool{"idle": false, "notified": true, "active": true} {
+		if _, kept := rl.visitors[id]; kept != want {
+			t.Errorf("visitor %s kept = %v, want %v", id, kept, want)
+		}
+	}
+}
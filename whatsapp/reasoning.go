@@ -0,0 +1,141 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	wtypes "go.mau.fi/whatsmeow/types"
+)
+
+const (
+	// maxReasoningChars is the length of the condensed reasoning trace
+	maxReasoningChars = 600
+	// emptyAnswerNotice replaces an answer that was all reasoning
+	emptyAnswerNotice = "Sorry, I ran out of room before I could answer. Please try again, maybe with a shorter question."
+)
+
+// splitReasoning separates a reasoning model's <think> block from its
+// answer. Some chat templates put the opening tag in the prompt, so text
+// before a lone closing tag is reasoning too, and an answer cut off while
+// still thinking is all reasoning.
+func splitReasoning(content string) (answer, reasoning string) {
+	const open, close = "<think>", "</think>"
+
+	var thoughts []string
+	for {
+		end := strings.Index(content, close)
+		start := strings.Index(content, open)
+		switch {
+		case end >= 0 && (start < 0 || start > end):
+			thoughts = append(thoughts, content[:end])
+			content = content[end+len(close):]
+		case start >= 0 && end >= 0:
+			thoughts = append(thoughts, content[start+len(open):end])
+			content = content[:start] + content[end+len(close):]
+		case start >= 0:
+			thoughts = append(thoughts, content[start+len(open):])
+			content = content[:start]
+		default:
+			return strings.TrimSpace(content), strings.TrimSpace(strings.Join(thoughts, "\n"))
+		}
+	}
+}
+
+// errEmptyAnswer marks a model answer that was all reasoning, a reasoning
+// model may spend its whole token budget thinking. It is not cached.
+var errEmptyAnswer = errors.New("answer was empty")
+
+// empty reports whether the answer has no text besides the reasoning
+func (c completion) empty() bool {
+	return strings.TrimSpace(c.content) == ""
+}
+
+// replyText is the text sent for an answer: a notice if it was empty,
+// otherwise the answer with the "more" hint if it was cut off
+func (b *Bot) replyText(c completion) string {
+	if c.empty() {
+		return emptyAnswerNotice
+	}
+	return b.withMoreHint(c)
+}
+
+// joinReasoning combines reasoning from separate sources or requests
+func joinReasoning(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, "\n\n")
+}
+
+// condenseReasoning shortens a reasoning trace for a chat message. It keeps
+// whole sentences from the start and the conclusion at the end, which is
+// usually the part worth reading.
+func condenseReasoning(reasoning string) string {
+	text := strings.Join(strings.Fields(reasoning), " ")
+	if len([]rune(text)) <= maxReasoningChars {
+		return text
+	}
+
+	sentences := strings.SplitAfter(text, ". ")
+	var head, tail []string
+	length := 0
+	for i, j := 0, len(sentences)-1; i <= j; {
+		if n := len([]rune(sentences[i])); length+n <= maxReasoningChars/2 || len(head) == 0 {
+			head = append(head, sentences[i])
+			length += n
+			i++
+		} else if n := len([]rune(sentences[j])); length+n <= maxReasoningChars {
+			tail = append([]string{sentences[j]}, tail...)
+			length += n
+			j--
+		} else {
+			break
+		}
+	}
+
+	condensed := strings.TrimSpace(strings.Join(head, ""))
+	if runes := []rune(condensed); len(runes) > maxReasoningChars {
+		condensed = string(runes[:maxReasoningChars])
+	}
+	if len(tail) > 0 {
+		condensed += " … " + strings.TrimSpace(strings.Join(tail, ""))
+	} else {
+		condensed += " …"
+	}
+	return condensed
+}
+
+// toggleReasoning switches showing reasoning in a chat and returns the new
+// setting
+func (b *Bot) toggleReasoning(chatID string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	conv, exists := b.conversations[chatID]
+	if !exists {
+		return false
+	}
+	conv.ShowReasoning = !conv.ShowReasoning
+	return conv.ShowReasoning
+}
+
+// sendReasoning sends a condensed reasoning trace if the chat asked for it
+func (b *Bot) sendReasoning(chat wtypes.JID, chatID, reasoning string) {
+	b.mutex.RLock()
+	show := false
+	if conv, exists := b.conversations[chatID]; exists {
+		show = conv.ShowReasoning
+	}
+	b.mutex.RUnlock()
+
+	if !show || reasoning == "" {
+		return
+	}
+	if err := b.sendText(chat, "💭 *Reasoning*\n"+condenseReasoning(reasoning), nil); err != nil {
+		fmt.Printf("Error sending reasoning: %v\n", err)
+	}
+}
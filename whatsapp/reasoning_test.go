@@ -0,0 +1,86 @@
+package whatsapp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitReasoning(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		answer    string
+		reasoning string
+	}{
+		{"no reasoning", "Hello there", "Hello there", ""},
+		{"think block", "<think>hmm</think>\nHello", "Hello", "hmm"},
+		{"opening tag in prompt", "hmm, let me see</think>Hello", "Hello", "hmm, let me see"},
+		{"cut off while thinking", "<think>still going", "", "still going"},
+		{"two blocks", "<think>a</think>Hello <think>b</think>world", "Hello world", "a\nb"},
+		{"answer before block", "Hi <think>x</think>", "Hi", "x"},
+		{"only closing tag", "</think>", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, reasoning := splitReasoning(tt.content)
+			if answer != tt.answer || reasoning != tt.reasoning {
+				t.Errorf("splitReasoning(%q) = %q, %q, want %q, %q",
+					tt.content, answer, reasoning, tt.answer, tt.reasoning)
+			}
+		})
+	}
+}
+
+func TestJoinReasoning(t *testing.T) {
+	tests := []struct {
+		parts []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"", "  "}, ""},
+		{[]string{"a", "", " b "}, "a\n\nb"},
+	}
+	for _, tt := range tests {
+		if got := joinReasoning(tt.parts...); got != tt.want {
+			t.Errorf("joinReasoning(%q) = %q, want %q", tt.parts, got, tt.want)
+		}
+	}
+}
+
+func TestCondenseReasoning(t *testing.T) {
+	sentence := strings.Repeat("x", 90) + ". "
+	tests := []struct {
+		name      string
+		reasoning string
+		want      func(string) bool
+	}{
+		{
+			"short kept whole",
+			"First  I think.\nThen   I answer.",
+			func(s string) bool { return s == "First I think. Then I answer." },
+		},
+		{
+			"keeps start and conclusion",
+			"Start here. " + strings.Repeat(sentence, 20) + "So the answer is 42.",
+			func(s string) bool {
+				return strings.HasPrefix(s, "Start here.") && strings.HasSuffix(s, "So the answer is 42.") && strings.Contains(s, " … ")
+			},
+		},
+		{
+			"one long sentence is cut",
+			strings.Repeat("y", 2000),
+			func(s string) bool { return s == strings.Repeat("y", maxReasoningChars)+" …" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := condenseReasoning(tt.reasoning)
+			if !tt.want(got) {
+				t.Errorf("condenseReasoning = %q", got)
+			}
+			if n := len([]rune(got)); n > maxReasoningChars+len(" … ") {
+				t.Errorf("condensed reasoning has %d characters", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package whatsapp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// defaultReminderHour is used when a reminder names a day but no time
+const defaultReminderHour = 9
+
+var (
+	reminderRe   = regexp.MustCompile(`(?i)^\s*remind me\b[\s,:]*(.*)$`)
+	inRe         = regexp.MustCompile(`(?i)\bin (\d+|a|an|one|half an?) ?(minutes?|mins?|hours?|hrs?|days?|weeks?)\b`)
+	dateRe       = regexp.MustCompile(`(?i)\b(?:on )?(\d{4}-\d{2}-\d{2})\b`)
+	dayRe        = regexp.MustCompile(`(?i)\b(today|tonight|tomorrow|(?:on |next )?(?:monday|tuesday|wednesday|thursday|friday|saturday|sunday))\b`)
+	clockRe      = regexp.MustCompile(`(?i)\b(?:at )?(\d{1,2})(?::(\d{2}))? ?(am|pm)\b|\bat (\d{1,2})(?::(\d{2}))?\b|\b(?:at )?(noon|midnight)\b`)
+	taskPrefixRe = regexp.MustCompile(`(?i)^(to|that|about)\s+`)
+	// modelReminderRe is the answer format asked of the model
+	modelReminderRe = regexp.MustCompile(`(\d{4}-\d{2}-\d{2} \d{1,2}:\d{2})\s*\|\s*(.+)`)
+)
+
+// handleReminder schedules a reminder if text asks for one. It reports
+// whether text was a reminder request, answered here.
+func (b *Bot) handleReminder(msg *events.Message, chatID, text string) bool {
+	cfg := b.getConfig()
+	m := reminderRe.FindStringSubmatch(text)
+	if !cfg.Scheduler.Reminders || m == nil {
+		return false
+	}
+
+	loc := cfg.schedulerLocation()
+	now := time.Now().In(loc)
+	when, task, ok := parseReminder(m[1], now)
+	if !ok {
+		when, task, ok = b.parseReminderWithModel(m[1], now)
+	}
+
+	reply := func(text string) bool {
+		if err := b.sendText(msg.Info.Chat, text, nil); err != nil {
+			fmt.Printf("Error answering reminder request: %v\n", err)
+		}
+		return true
+	}
+	switch {
+	case !ok:
+		return reply("I couldn't tell when to remind you. Try something like \"remind me tomorrow at 9 to call the bank\" or \"remind me in 2 hours to check the oven\".")
+	case !when.After(now):
+		return reply("That time has already passed. When should I remind you?")
+	}
+
+	max := cfg.Scheduler.MaxReminders
+	if max <= 0 {
+		max = defaultMaxReminders
+	}
+	pending, err := b.accountManager.store.ListScheduled(b.botID, chatID)
+	if err != nil {
+		return reply("I couldn't save the reminder right now. Please try again later.")
+	}
+	reminders := 0
+	for _, job := range pending {
+		if job.Kind == JobReminder {
+			reminders++
+		}
+	}
+	if reminders >= max {
+		return reply(fmt.Sprintf("You already have %d pending reminders. Send %sreminders to see or cancel them.", reminders, commandPrefix))
+	}
+
+	id, err := b.accountManager.store.AddScheduled(storage.ScheduledMessage{
+		BotID:     b.botID,
+		Chat:      chatID,
+		Text:      task,
+		Kind:      JobReminder,
+		NextRun:   when,
+		CreatedBy: msg.Info.Sender.ToNonAD().String(),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		fmt.Printf("Error saving reminder: %v\n", err)
+		return reply("I couldn't save the reminder right now. Please try again later.")
+	}
+	return reply(fmt.Sprintf("Okay, I'll remind you %s to %s. (Reminder %d, send %scancel %d to cancel it.)",
+		formatWhen(when, now), task, id, commandPrefix, id))
+}
+
+// parseReminder reads when and what to remind from English text like
+// "tomorrow at 9 to call the bank" or "to stretch in 20 minutes"
+func parseReminder(text string, now time.Time) (time.Time, string, bool) {
+	rest := text
+	var when time.Time
+
+	if m := inRe.FindStringSubmatch(rest); m != nil {
+		rest = strings.Replace(rest, m[0], " ", 1)
+		amount := 1.0
+		switch strings.ToLower(m[1]) {
+		case "a", "an", "one":
+		case "half a", "half an":
+			amount = 0.5
+		default:
+			n, _ := strconv.Atoi(m[1])
+			amount = float64(n)
+		}
+		unit := time.Minute
+		switch strings.ToLower(m[2])[0] {
+		case 'h':
+			unit = time.Hour
+		case 'd':
+			unit = 24 * time.Hour
+		case 'w':
+			unit = 7 * 24 * time.Hour
+		}
+		when = now.Add(time.Duration(amount * float64(unit)))
+	} else {
+		day, dayGiven := now, false
+		if m := dateRe.FindStringSubmatch(rest); m != nil {
+			d, err := time.ParseInLocation("2006-01-02", m[1], now.Location())
+			if err != nil {
+				return time.Time{}, "", false
+			}
+			rest = strings.Replace(rest, m[0], " ", 1)
+			day, dayGiven = d, true
+		} else if m := dayRe.FindStringSubmatch(rest); m != nil {
+			rest = strings.Replace(rest, m[0], " ", 1)
+			day, dayGiven = resolveDay(strings.ToLower(m[1]), now), true
+		}
+
+		hour, minute, timeGiven := defaultReminderHour, 0, false
+		if m := clockRe.FindStringSubmatch(rest); m != nil {
+			rest = strings.Replace(rest, m[0], " ", 1)
+			hour, minute, timeGiven = resolveClock(m)
+			if !timeGiven {
+				return time.Time{}, "", false
+			}
+		} else if dayGiven && strings.Contains(strings.ToLower(text), "tonight") {
+			hour = 20
+		}
+		if !dayGiven && !timeGiven {
+			return time.Time{}, "", false
+		}
+
+		when = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+		// "at 9" without a day means the next 9 o'clock
+		if !dayGiven && !when.After(now) {
+			when = when.AddDate(0, 0, 1)
+		}
+	}
+
+	task := strings.Join(strings.Fields(rest), " ")
+	task = strings.TrimRight(taskPrefixRe.ReplaceAllString(task, ""), ".!? ")
+	if task == "" {
+		return time.Time{}, "", false
+	}
+	return when, task, true
+}
+
+// resolveDay returns the date named by "today", "tomorrow" or a weekday
+func resolveDay(name string, now time.Time) time.Time {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "on "), "next ")
+	switch name {
+	case "today", "tonight":
+		return now
+	case "tomorrow":
+		return now.AddDate(0, 0, 1)
+	}
+	for d := 1; d <= 7; d++ {
+		day := now.AddDate(0, 0, d)
+		if strings.EqualFold(day.Weekday().String(), name) {
+			return day
+		}
+	}
+	return now
+}
+
+// resolveClock reads the hour and minute of a clockRe match
+func resolveClock(m []string) (hour, minute int, ok bool) {
+	switch strings.ToLower(m[6]) {
+	case "noon":
+		return 12, 0, true
+	case "midnight":
+		return 0, 0, true
+	}
+
+	h, mm, suffix := m[1], m[2], strings.ToLower(m[3])
+	if h == "" {
+		h, mm = m[4], m[5]
+	}
+	hour, _ = strconv.Atoi(h)
+	if mm != "" {
+		minute, _ = strconv.Atoi(mm)
+	}
+	switch {
+	case suffix == "pm" && hour < 12:
+		hour += 12
+	case suffix == "am" && hour == 12:
+		hour = 0
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// parseReminderWithModel asks the model to read a reminder request the
+// rules above don't understand
+func (b *Bot) parseReminderWithModel(text string, now time.Time) (time.Time, string, bool) {
+	prompt := fmt.Sprintf("It is now %s (%s). A user asked: \"remind me %s\".\n"+
+		"Reply with exactly one line in the form \"YYYY-MM-DD HH:MM | task\" giving when to remind them and what about, "+
+		"or NONE if the request doesn't say when.", now.Format("Monday 2006-01-02 15:04"), now.Location(), text)
+	answer, _, _, err := b.makeIndependentAIRequest(RequestSummary, prompt, b.requestTimeout(RequestSummary, len(prompt)))
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	m := modelReminderRe.FindStringSubmatch(answer)
+	if m == nil {
+		return time.Time{}, "", false
+	}
+	when, err := time.ParseInLocation("2006-01-02 15:04", m[1], now.Location())
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return when, strings.TrimSpace(m[2]), true
+}
+
+// formatWhen describes a time relative to now, e.g. "tomorrow at 09:00"
+func formatWhen(when, now time.Time) string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch days := int(when.Sub(today).Hours() / 24); {
+	case days == 0:
+		return "today at " + when.Format("15:04")
+	case days == 1:
+		return "tomorrow at " + when.Format("15:04")
+	case days < 7:
+		return "on " + when.Format("Monday") + " at " + when.Format("15:04")
+	}
+	return "on " + when.Format("Mon 2 Jan 2006") + " at " + when.Format("15:04")
+}
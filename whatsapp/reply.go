@@ -0,0 +1,53 @@
+package whatsapp
+
+import (
+	"fmt"
+
+	"whatsapp-gpt-bot/utils"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	wtypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// sendReply sends a model answer to chat. Oversized code blocks and tables
+// are sent as files after the text. The text is converted from Markdown to
+// WhatsApp formatting and split into numbered parts if it is longer than
+// MAX_WHATSAPP_CHARS. A quote is attached to the first part.
+func (b *Bot) sendReply(chat wtypes.JID, text string, quote *waE2E.ContextInfo) error {
+	cfg := b.getConfig().Attachments
+	text, files := utils.ExtractAttachments(text, cfg.CodeLines, cfg.TableRows, cfg.TableColumns)
+	if err := b.sendText(chat, text, quote); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := b.sendDocument(chat, file); err != nil {
+			fmt.Printf("Error sending %s, sending it inline: %v\n", file.FileName, err)
+			if err := b.sendText(chat, file.Inline, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sendText sends formatted text, split into parts as needed
+func (b *Bot) sendText(chat wtypes.JID, text string, quote *waE2E.ContextInfo) error {
+	parts := utils.SplitMessage(utils.FormatWhatsApp(text), MAX_WHATSAPP_CHARS)
+	for i, part := range parts {
+		msg := &waE2E.Message{Conversation: proto.String(part)}
+		if i == 0 && quote != nil {
+			msg = &waE2E.Message{
+				ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+					Text:        proto.String(part),
+					ContextInfo: quote,
+				},
+			}
+		}
+		if _, err := b.send(chat, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Rule matchers
+const (
+	MatchExact   = "exact"
+	MatchKeyword = "keyword"
+	MatchRegex   = "regex"
+	MatchFuzzy   = "fuzzy"
+)
+
+const (
+	defaultFuzzyThreshold = 0.85
+	// rulesCheckInterval is how often the rules file is checked for changes
+	rulesCheckInterval = 5 * time.Second
+)
+
+// RulesConfig points a bot at its rules file
+type RulesConfig struct {
+	// File is a JSON file of rules, reloaded when it changes; empty
+	// disables rules
+	File string `json:"file"`
+}
+
+// Rule maps messages to a canned reply that is sent without asking the model
+type Rule struct {
+	Name  string `json:"name"`
+	Match string `json:"match"`
+	// Patterns are the texts, keywords or expressions to match; the rule
+	// applies if any of them matches
+	Patterns []string `json:"patterns"`
+	// All requires every keyword to appear (keyword only)
+	All bool `json:"all"`
+	// Threshold is the minimum similarity from 0 to 1 (fuzzy only)
+	Threshold float64 `json:"threshold"`
+	// Reply is a text/template with .Name, .Phone, .Time, .Vars and, for
+	// regex rules, .Groups
+	Reply string `json:"reply"`
+}
+
+// rulesFile is the format of a rules file
+type rulesFile struct {
+	Rules []Rule `json:"rules"`
+	// Vars are shared values for the reply templates
+	Vars map[string]string `json:"vars"`
+}
+
+// RuleStat counts how often a rule has answered
+type RuleStat struct {
+	Name    string
+	Match   string
+	Hits    int64
+	LastHit time.Time
+}
+
+type compiledRule struct {
+	Rule
+	regexps  []*regexp.Regexp
+	patterns []string
+	reply    *template.Template
+}
+
+// ruleTemplateData is what reply templates can use
+type ruleTemplateData struct {
+	Name   string
+	Phone  string
+	Time   time.Time
+	Vars   map[string]string
+	Groups []string
+}
+
+// ruleEngine holds a bot's rules and reloads them when the file changes
+type ruleEngine struct {
+	mutex   sync.Mutex
+	path    string
+	modTime time.Time
+	checked time.Time
+	rules   []*compiledRule
+	vars    map[string]string
+	stats   map[string]*RuleStat
+}
+
+func newRuleEngine() *ruleEngine {
+	return &ruleEngine{stats: make(map[string]*RuleStat)}
+}
+
+// refresh loads path if it changed since the last look. A broken file
+// keeps the previous rules.
+func (e *ruleEngine) refresh(path string) {
+	if path == e.path && time.Since(e.checked) < rulesCheckInterval {
+		return
+	}
+	e.checked = time.Now()
+
+	if path == "" {
+		e.path, e.rules, e.vars = "", nil, nil
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if path != e.path {
+			fmt.Printf("Error reading rules %s: %v\n", path, err)
+			e.path, e.rules, e.vars = path, nil, nil
+		}
+		return
+	}
+	if path == e.path && info.ModTime().Equal(e.modTime) {
+		return
+	}
+
+	rules, vars, err := loadRules(path)
+	e.path, e.modTime = path, info.ModTime()
+	if err != nil {
+		fmt.Printf("Error loading rules %s: %v\n", path, err)
+		return
+	}
+	e.rules, e.vars = rules, vars
+	fmt.Printf("Loaded %d rules from %s\n", len(rules), path)
+}
+
+func loadRules(path string) ([]*compiledRule, map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var file rulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, nil, err
+	}
+
+	rules := make([]*compiledRule, 0, len(file.Rules))
+	for i, r := range file.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		cr := &compiledRule{Rule: r}
+		cr.reply, err = template.New(r.Name).Option("missingkey=zero").Parse(r.Reply)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", r.Name, err)
+		}
+
+		switch r.Match {
+		case MatchRegex:
+			for _, p := range r.Patterns {
+				re, err := regexp.Compile(p)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %v", r.Name, err)
+				}
+				cr.regexps = append(cr.regexps, re)
+			}
+		case MatchExact, MatchKeyword, MatchFuzzy:
+			for _, p := range r.Patterns {
+				cr.patterns = append(cr.patterns, normalizeText(p))
+			}
+			if r.Match == MatchFuzzy && cr.Threshold <= 0 {
+				cr.Threshold = defaultFuzzyThreshold
+			}
+		default:
+			return nil, nil, fmt.Errorf("%s: unknown matcher %q", r.Name, r.Match)
+		}
+		rules = append(rules, cr)
+	}
+	return rules, file.Vars, nil
+}
+
+// match returns the first rule matching text, in file order, with the
+// regex groups of the match
+func (e *ruleEngine) match(text string) (*compiledRule, []string) {
+	normalized := normalizeText(text)
+	for _, r := range e.rules {
+		switch r.Match {
+		case MatchExact:
+			for _, p := range r.patterns {
+				if normalized == p {
+					return r, nil
+				}
+			}
+		case MatchKeyword:
+			words := " " + normalized + " "
+			found := 0
+			for _, p := range r.patterns {
+				if strings.Contains(words, " "+p+" ") {
+					found++
+				}
+			}
+			if (r.All && found == len(r.patterns) && found > 0) || (!r.All && found > 0) {
+				return r, nil
+			}
+		case MatchRegex:
+			for _, re := range r.regexps {
+				if groups := re.FindStringSubmatch(text); groups != nil {
+					return r, groups
+				}
+			}
+		case MatchFuzzy:
+			for _, p := range r.patterns {
+				if similarity(normalized, p) >= r.Threshold {
+					return r, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// reply returns the canned reply for text under the rules in path, if a
+// rule matches, and counts the hit
+func (e *ruleEngine) reply(path, text string, data ruleTemplateData) (string, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.refresh(path)
+	rule, groups := e.match(text)
+	if rule == nil {
+		return "", false
+	}
+
+	data.Vars, data.Groups = e.vars, groups
+	var sb strings.Builder
+	if err := rule.reply.Execute(&sb, data); err != nil {
+		fmt.Printf("Error rendering rule %s: %v\n", rule.Name, err)
+		return "", false
+	}
+
+	stat, exists := e.stats[rule.Name]
+	if !exists {
+		stat = &RuleStat{Name: rule.Name, Match: rule.Match}
+		e.stats[rule.Name] = stat
+	}
+	stat.Hits++
+	stat.LastHit = time.Now()
+	return sb.String(), true
+}
+
+// statistics returns the hit counts of the loaded rules in file order,
+// followed by rules that have since been removed
+func (e *ruleEngine) statistics() []RuleStat {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	stats := make([]RuleStat, 0, len(e.stats))
+	listed := make(map[string]bool)
+	for _, r := range e.rules {
+		stat := RuleStat{Name: r.Name, Match: r.Match}
+		if s, exists := e.stats[r.Name]; exists {
+			stat = *s
+		}
+		stats = append(stats, stat)
+		listed[r.Name] = true
+	}
+	var removed []RuleStat
+	for name, s := range e.stats {
+		if !listed[name] {
+			removed = append(removed, *s)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Name < removed[j].Name })
+	return append(stats, removed...)
+}
+
+// normalizeText lowercases text and reduces it to words separated by
+// single spaces
+func normalizeText(text string) string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+// similarity is 1 minus the edit distance of a and b relative to the
+// longer one
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// ruleReply returns the canned reply for a message if one of the bot's
+// rules matches it
+func (b *Bot) ruleReply(msg *events.Message, text string) (string, bool) {
+	return b.rules.reply(b.getConfig().Rules.File, text, ruleTemplateData{
+		Name:  msg.Info.PushName,
+		Phone: msg.Info.Sender.User,
+		Time:  time.Now(),
+	})
+}
+
+// RuleStats returns the rule hit counters of every bot
+func (am *AccountManager) RuleStats() map[string][]RuleStat {
+	stats := make(map[string][]RuleStat)
+	for id, bot := range am.ListBots() {
+		stats[id] = bot.rules.statistics()
+	}
+	return stats
+}
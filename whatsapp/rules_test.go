@@ -0,0 +1,102 @@
+package whatsapp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testRules = `{
+	"vars": {"hours": "9 to 5"},
+	"rules": [
+		{"name": "greeting", "match": "exact", "patterns": ["hi", "hello there"], "reply": "Hi {{.Name}}!"},
+		{"name": "hours", "match": "keyword", "patterns": ["open", "hours"], "reply": "We're open {{.Vars.hours}}."},
+		{"name": "refund", "match": "keyword", "all": true, "patterns": ["refund", "order"], "reply": "Refunds take 5 days."},
+		{"name": "order", "match": "regex", "patterns": ["(?i)order #(\\d+)"], "reply": "Looking up order {{index .Groups 1}}."},
+		{"name": "thanks", "match": "fuzzy", "patterns": ["thank you so much"], "reply": "You're welcome!"}
+	]
+}`
+
+func TestRuleEngineReply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(testRules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine := newRuleEngine()
+	data := ruleTemplateData{Name: "Ana"}
+
+	tests := []struct {
+		text  string
+		reply string
+		ok    bool
+	}{
+		{"Hi", "Hi Ana!", true},
+		{"Hello, there!", "Hi Ana!", true},
+		{"hi, can you help?", "", false},
+		{"Are you OPEN today?", "We're open 9 to 5.", true},
+		{"reopen", "", false},
+		{"refund please", "", false},
+		{"I want a refund for my order", "Refunds take 5 days.", true},
+		{"where is ORDER #1234", "Looking up order 1234.", true},
+		{"thank you so much!", "You're welcome!", true},
+		{"thank you so mcuh", "You're welcome!", true},
+		{"thanks", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			reply, ok := engine.reply(path, tt.text, data)
+			if ok != tt.ok || reply != tt.reply {
+				t.Errorf("reply(%q) = %q, %v, want %q, %v", tt.text, reply, ok, tt.reply, tt.ok)
+			}
+		})
+	}
+
+	hits := make(map[string]int64)
+	for _, s := range engine.statistics() {
+		hits[s.Name] = s.Hits
+	}
+	want := map[string]int64{"greeting": 2, "hours": 1, "refund": 1, "order": 1, "thanks": 2}
+	for name, n := range want {
+		if hits[name] != n {
+			t.Errorf("rule %s has %d hits, want %d", name, hits[name], n)
+		}
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad json":        `{"rules": [`,
+		"unknown matcher": `{"rules": [{"match": "glob", "patterns": ["*"], "reply": "x"}]}`,
+		"bad regex":       `{"rules": [{"match": "regex", "patterns": ["("], "reply": "x"}]}`,
+		"bad template":    `{"rules": [{"match": "exact", "patterns": ["x"], "reply": "{{.Name"}]}`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := loadRules(path); err == nil {
+				t.Error("loadRules succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"abc", "abc", 1},
+		{"abc", "", 0},
+		{"kitten", "sitting", 1 - 3.0/7},
+		{"héllo", "hello", 0.8},
+	}
+	for _, tt := range tests {
+		if got := similarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
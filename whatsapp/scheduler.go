@@ -0,0 +1,281 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"whatsapp-gpt-bot/storage"
+	"whatsapp-gpt-bot/utils"
+
+	wtypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Kinds of scheduled messages
+const (
+	// JobReminder is a reminder a contact asked for in a chat
+	JobReminder = "reminder"
+	// JobMessage is a message an operator scheduled
+	JobMessage = "message"
+)
+
+const (
+	schedulerInterval   = 30 * time.Second
+	defaultMissedGrace  = 6 * time.Hour
+	defaultMaxReminders = 20
+	// lateNoticeAfter is how late a reminder must be to say so
+	lateNoticeAfter = 5 * time.Minute
+)
+
+// SchedulerConfig controls scheduled messages and reminders
+type SchedulerConfig struct {
+	// Reminders lets contacts ask for reminders ("remind me tomorrow at 9
+	// to call the bank")
+	Reminders bool `json:"reminders"`
+	// Timezone is the IANA zone reminders and cron schedules are read in,
+	// the business hours' zone or UTC if empty
+	Timezone string `json:"timezone"`
+	// MissedGrace is how late a message may still be sent after downtime;
+	// older ones are marked missed, recurring ones skip to their next run
+	MissedGrace Duration `json:"missed_grace"`
+	// MaxReminders limits the pending reminders per chat
+	MaxReminders int `json:"max_reminders"`
+}
+
+// schedulerLocation returns the time zone of the bot's schedules
+func (cfg *BotConfig) schedulerLocation() *time.Location {
+	if cfg.Scheduler.Timezone != "" {
+		return BusinessHoursConfig{Timezone: cfg.Scheduler.Timezone}.location()
+	}
+	return cfg.BusinessHours.location()
+}
+
+// runScheduler sends due scheduled messages until the manager is closed
+func (am *AccountManager) runScheduler() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			am.runDueJobs()
+		case <-am.done:
+			return
+		}
+	}
+}
+
+// runDueJobs sends every due message whose bot is connected. Messages of
+// bots that are offline stay pending and are handled when they are back,
+// those of removed bots fail.
+func (am *AccountManager) runDueJobs() {
+	jobs, err := am.store.DueScheduled(time.Now())
+	if err != nil {
+		fmt.Printf("Error loading scheduled messages: %v\n", err)
+		return
+	}
+	for _, job := range jobs {
+		bot, exists := am.GetBot(job.BotID)
+		if !exists {
+			if am.botsLoaded() {
+				am.failJob(job, "bot "+job.BotID+" no longer exists")
+			}
+			continue
+		}
+		if !bot.IsConnected() {
+			continue
+		}
+		bot.runJob(job)
+	}
+}
+
+// failJob marks a scheduled message that can never be sent as failed
+func (am *AccountManager) failJob(job storage.ScheduledMessage, reason string) {
+	fmt.Printf("Scheduled message %d failed: %s\n", job.ID, reason)
+	if err := am.store.UpdateScheduled(job.ID, storage.JobFailed, job.LastRun, job.NextRun); err != nil {
+		fmt.Printf("Error updating scheduled message %d: %v\n", job.ID, err)
+	}
+}
+
+// runJob sends a due scheduled message and works out its next run
+func (b *Bot) runJob(job storage.ScheduledMessage) {
+	cfg := b.getConfig()
+	now := time.Now()
+	grace := time.Duration(cfg.Scheduler.MissedGrace)
+	if grace <= 0 {
+		grace = defaultMissedGrace
+	}
+	late := now.Sub(job.NextRun)
+
+	chat, err := wtypes.ParseJID(job.Chat)
+	if err != nil {
+		// Recurring messages too, every run would fail the same way
+		b.accountManager.failJob(job, "invalid chat "+job.Chat)
+		return
+	}
+
+	status, sent := storage.JobSent, false
+	if late > grace {
+		status = storage.JobMissed
+		fmt.Printf("Scheduled message %d missed, it was due %s\n", job.ID, job.NextRun.Format(time.RFC3339))
+	} else if err := b.sendText(chat, jobText(job, late, cfg.schedulerLocation()), nil); err != nil {
+		// Retried on the next tick, until it is too late
+		fmt.Printf("Error sending scheduled message %d: %v\n", job.ID, err)
+		return
+	} else {
+		sent = true
+	}
+
+	next := job.NextRun
+	if job.Cron != "" {
+		if sched, err := utils.ParseCron(job.Cron); err == nil {
+			// Runs missed during downtime are skipped, not caught up
+			if next = sched.Next(now.In(cfg.schedulerLocation())); !next.IsZero() {
+				status = storage.JobPending
+			}
+		}
+	}
+	lastRun := job.LastRun
+	if sent {
+		lastRun = now
+		if job.Kind == JobReminder {
+			b.recordReminder(job)
+		}
+	}
+	if err := b.accountManager.store.UpdateScheduled(job.ID, status, lastRun, next); err != nil {
+		fmt.Printf("Error updating scheduled message %d: %v\n", job.ID, err)
+	}
+}
+
+// jobText is the message sent for a job, noting reminders that are late
+func jobText(job storage.ScheduledMessage, late time.Duration, loc *time.Location) string {
+	if job.Kind != JobReminder {
+		return job.Text
+	}
+	text := "⏰ Reminder: " + job.Text
+	if late > lateNoticeAfter {
+		text += fmt.Sprintf("\n(This was due at %s, sorry for the delay.)", job.NextRun.In(loc).Format("Mon 15:04"))
+	}
+	return text
+}
+
+// recordReminder keeps a sent reminder in the chat's history
+func (b *Bot) recordReminder(job storage.ScheduledMessage) {
+	if err := b.initConversation(job.Chat); err != nil {
+		return
+	}
+	b.appendHistory(job.Chat, BotMessage{Role: "assistant", Content: "Reminder: " + job.Text, Time: time.Now()})
+}
+
+// ScheduleMessage schedules text to be sent to a contact by a bot, once at
+// at or, if cron is set, on that schedule in the bot's time zone
+func (am *AccountManager) ScheduleMessage(botID, contact string, at time.Time, cron, text, createdBy string) (int64, error) {
+	bot, exists := am.GetBot(botID)
+	if !exists {
+		return 0, fmt.Errorf("bot %s not found", botID)
+	}
+	if strings.TrimSpace(text) == "" {
+		return 0, fmt.Errorf("message text is empty")
+	}
+
+	next := at
+	if cron != "" {
+		sched, err := utils.ParseCron(cron)
+		if err != nil {
+			return 0, err
+		}
+		next = sched.Next(time.Now().In(bot.getConfig().schedulerLocation()))
+		if next.IsZero() {
+			return 0, fmt.Errorf("cron expression %q never runs", cron)
+		}
+	} else if !at.After(time.Now()) {
+		return 0, fmt.Errorf("%s is in the past", at.Format(time.RFC3339))
+	}
+
+	return am.store.AddScheduled(storage.ScheduledMessage{
+		BotID:     botID,
+		Chat:      normalizeContact(contact),
+		Text:      text,
+		Kind:      JobMessage,
+		Cron:      cron,
+		NextRun:   next,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	})
+}
+
+// ScheduledMessages returns a bot's pending scheduled messages
+func (am *AccountManager) ScheduledMessages(botID string) ([]storage.ScheduledMessage, error) {
+	return am.store.ListScheduled(botID, "")
+}
+
+// CancelScheduled cancels a pending scheduled message of a bot
+func (am *AccountManager) CancelScheduled(botID string, id int64) error {
+	cancelled, err := am.store.CancelScheduled(botID, "", id)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return fmt.Errorf("no pending message %d for %s", id, botID)
+	}
+	return nil
+}
+
+// ScheduleLocation returns the time zone a bot reads schedule times in
+func (am *AccountManager) ScheduleLocation(botID string) *time.Location {
+	if bot, exists := am.GetBot(botID); exists {
+		return bot.getConfig().schedulerLocation()
+	}
+	return time.UTC
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "reminders",
+		Description: "List your pending reminders",
+		Handler:     remindersCommand,
+	})
+	RegisterCommand(Command{
+		Name:        "cancel",
+		Usage:       "<number>",
+		Description: "Cancel a reminder",
+		Handler:     cancelReminderCommand,
+	})
+}
+
+func remindersCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	jobs, err := b.accountManager.store.ListScheduled(b.botID, chatID)
+	if err != nil {
+		return "I couldn't load your reminders right now."
+	}
+
+	loc := b.getConfig().schedulerLocation()
+	var sb strings.Builder
+	for _, job := range jobs {
+		if job.Kind != JobReminder {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n%d. %s - %s", job.ID, job.NextRun.In(loc).Format("Mon 2 Jan 15:04"), job.Text)
+	}
+	if sb.Len() == 0 {
+		return "You have no pending reminders. Try \"remind me tomorrow at 9 to call the bank\"."
+	}
+	return "*Your reminders*" + sb.String() + "\n\nSend " + commandPrefix + "cancel <number> to cancel one."
+}
+
+func cancelReminderCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	id, err := strconv.ParseInt(strings.TrimPrefix(args, "#"), 10, 64)
+	if err != nil {
+		return "Usage: " + commandPrefix + "cancel <number>, see " + commandPrefix + "reminders"
+	}
+	cancelled, err := b.accountManager.store.CancelScheduled(b.botID, chatID, id)
+	if err != nil {
+		return "I couldn't cancel the reminder right now."
+	}
+	if !cancelled {
+		return fmt.Sprintf("There's no pending reminder %d in this chat.", id)
+	}
+	return fmt.Sprintf("Reminder %d cancelled.", id)
+}
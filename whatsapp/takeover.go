@@ -0,0 +1,225 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	wtypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	defaultTakeoverDuration = 30 * time.Minute
+	// sentMessageTTL is how long the IDs of our own messages are remembered,
+	// long enough for their echo from the phone to arrive
+	sentMessageTTL = 10 * time.Minute
+)
+
+// TakeoverConfig controls handing a chat over to a human operator. When an
+// operator writes to a contact from the phone, the bot stays quiet in that
+// chat for Duration after the operator's last message.
+type TakeoverConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Duration Duration `json:"duration"`
+}
+
+// sentMessages remembers the IDs of messages the bot sent itself, to tell
+// them apart from messages an operator sends from the phone
+type sentMessages struct {
+	mutex sync.Mutex
+	ids   map[wtypes.MessageID]time.Time
+}
+
+func newSentMessages() *sentMessages {
+	return &sentMessages{ids: make(map[wtypes.MessageID]time.Time)}
+}
+
+func (s *sentMessages) add(id wtypes.MessageID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for old, sent := range s.ids {
+		if now.Sub(sent) > sentMessageTTL {
+			delete(s.ids, old)
+		}
+	}
+	s.ids[id] = now
+}
+
+func (s *sentMessages) has(id wtypes.MessageID) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, exists := s.ids[id]
+	return exists
+}
+
+// send sends a message, remembering its ID before it goes out so its echo
+// is never taken for an operator's message
+func (b *Bot) send(chat wtypes.JID, msg *waE2E.Message) (whatsmeow.SendResponse, error) {
+	id := b.client.GenerateMessageID()
+	b.sent.add(id)
+	return b.client.SendMessage(context.Background(), chat, msg, whatsmeow.SendRequestExtra{ID: id})
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "bot",
+		Usage:       "on|off <number>",
+		Description: "Let the bot answer a chat again, or hand it to a human",
+		OwnerOnly:   true,
+		Handler:     botCommand,
+	})
+}
+
+// loadHumanMode reads the chats currently handled by a human
+func (b *Bot) loadHumanMode() {
+	chats, err := b.accountManager.store.ListHumanMode(b.botID, time.Now())
+	if err != nil {
+		fmt.Printf("Error loading human mode: %v\n", err)
+		return
+	}
+	b.moderationMux.Lock()
+	b.humanMode = chats
+	b.moderationMux.Unlock()
+}
+
+// setHumanMode hands chatID to a human until the given time, zero meaning
+// until /bot on. An indefinite takeover is never shortened by a timed one.
+func (b *Bot) setHumanMode(chatID string, until time.Time) {
+	b.moderationMux.Lock()
+	current, exists := b.humanMode[chatID]
+	if exists && current.IsZero() && !until.IsZero() {
+		b.moderationMux.Unlock()
+		return
+	}
+	b.humanMode[chatID] = until
+	b.moderationMux.Unlock()
+
+	b.turns.interrupt(chatID)
+	if err := b.accountManager.store.SetHumanMode(b.botID, chatID, until); err != nil {
+		fmt.Printf("Error saving human mode for %s: %v\n", chatID, err)
+	}
+}
+
+// clearHumanMode gives chatID back to the bot
+func (b *Bot) clearHumanMode(chatID string) {
+	b.moderationMux.Lock()
+	delete(b.humanMode, chatID)
+	b.moderationMux.Unlock()
+
+	if err := b.accountManager.store.ClearHumanMode(b.botID, chatID); err != nil {
+		fmt.Printf("Error clearing human mode for %s: %v\n", chatID, err)
+	}
+}
+
+// inHumanMode reports whether a human currently handles chatID
+func (b *Bot) inHumanMode(chatID string) bool {
+	b.moderationMux.RLock()
+	until, exists := b.humanMode[chatID]
+	b.moderationMux.RUnlock()
+
+	if !exists {
+		return false
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		b.clearHumanMode(chatID)
+		return false
+	}
+	return true
+}
+
+// handleOperatorMessage handles a message sent from the account to a
+// contact. Messages the bot sent itself are ignored; anything else was
+// written by a human, who takes the chat over.
+func (b *Bot) handleOperatorMessage(msg *events.Message) {
+	if b.sent.has(msg.Info.ID) {
+		return
+	}
+	chatID := msg.Info.Chat.String()
+	text := messageText(msg)
+
+	// "/bot on" and "/bot off" typed into the chat control it directly
+	if name, args, ok := parseCommand(text); ok && strings.EqualFold(name, "bot") {
+		result := b.switchBot(chatID, args)
+		b.audit(msg, "bot", args+" "+chatID, result)
+		fmt.Printf("Operator in %s: %s\n", chatID, result)
+		return
+	}
+
+	cfg := b.getConfig().Takeover
+	if !cfg.Enabled {
+		return
+	}
+	b.setHumanMode(chatID, time.Now().Add(time.Duration(cfg.Duration)))
+
+	if text == "" {
+		return
+	}
+	if err := b.initConversation(chatID); err != nil {
+		fmt.Printf("Error recording operator message: %v\n", err)
+		return
+	}
+	b.appendHistory(chatID, BotMessage{Role: "assistant", Content: text, Operator: true, Time: time.Now()})
+}
+
+// recordHumanModeMessage keeps a contact's message to a chat handled by a
+// human in the history, so the bot has the context when it takes over again
+func (b *Bot) recordHumanModeMessage(msg *events.Message, chatID string) {
+	text := messageText(msg)
+	if text == "" {
+		return
+	}
+	if err := b.initConversation(chatID); err != nil {
+		fmt.Printf("Error recording message: %v\n", err)
+		return
+	}
+	b.appendHistory(chatID, BotMessage{Role: "user", Content: text, Time: time.Now()})
+}
+
+// appendHistory adds a message to a chat's history, keeping it bounded
+func (b *Bot) appendHistory(chatID string, m BotMessage) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	conv := b.conversations[chatID]
+	conv.Messages = append(conv.Messages, m)
+	if len(conv.Messages) > MAX_HISTORY {
+		conv.Messages = conv.Messages[len(conv.Messages)-MAX_HISTORY:]
+	}
+	conv.LastActive = time.Now()
+}
+
+// switchBot turns the bot on or off in chatID
+func (b *Bot) switchBot(chatID, mode string) string {
+	switch strings.ToLower(mode) {
+	case "on":
+		b.clearHumanMode(chatID)
+		return "The bot answers " + chatID + " again"
+	case "off":
+		b.setHumanMode(chatID, time.Time{})
+		return "The bot is off in " + chatID + " until " + commandPrefix + "bot on"
+	}
+	return "Usage: " + commandPrefix + "bot on|off"
+}
+
+func botCommand(b *Bot, msg *events.Message, chatID, args string) string {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return "Usage: " + commandPrefix + "bot on|off <number>"
+	}
+	return b.switchBot(normalizeContact(fields[1]), fields[0])
+}
+
+// messageText returns the text of a plain or extended text message
+func messageText(msg *events.Message) string {
+	if text := msg.Message.GetConversation(); text != "" {
+		return text
+	}
+	return msg.Message.GetExtendedTextMessage().GetText()
+}
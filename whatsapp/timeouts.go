@@ -0,0 +1,321 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"whatsapp-gpt-bot/utils"
+)
+
+// Request kinds have their own latency statistics, a summary of a long
+// conversation takes much longer than a chat reply
+const (
+	RequestChat    = "chat"
+	RequestSummary = "summary"
+)
+
+const (
+	// Latency buckets grow by histogramFactor from histogramBase, which
+	// covers 100ms to about half an hour
+	histogramBase    = 100 * time.Millisecond
+	histogramFactor  = 1.25
+	histogramBuckets = 45
+	// histogramDecay fades old observations so the timeouts follow a
+	// server that got faster or slower
+	histogramDecay = 0.995
+	// minTimeoutSamples is how many observations are needed before the
+	// percentiles are trusted over the kind's initial timeout
+	minTimeoutSamples = 10
+	// maxInputScale caps how much a long prompt stretches the timeout
+	maxInputScale          = 3.0
+	timeoutPersistInterval = time.Minute
+)
+
+// kindTimeouts are the initial and maximum timeout of a request kind
+var kindTimeouts = map[string]struct{ initial, max time.Duration }{
+	RequestChat:    {INITIAL_TIMEOUT, MAX_TIMEOUT},
+	RequestSummary: {2 * MAX_TIMEOUT, DEFAULT_TIMEOUT},
+}
+
+// timeoutKey identifies a latency distribution
+type timeoutKey struct {
+	Provider string
+	Model    string
+	Kind     string
+}
+
+func (k timeoutKey) String() string {
+	return k.Provider + "|" + k.Model + "|" + k.Kind
+}
+
+// latencyHistogram is a decaying histogram of request latencies with
+// exponentially growing buckets
+type latencyHistogram struct {
+	Buckets []float64 `json:"buckets"`
+	Count   float64   `json:"count"`
+	// Input is the moving average prompt length in characters
+	Input    float64 `json:"input"`
+	Timeouts int64   `json:"timeouts"`
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{Buckets: make([]float64, histogramBuckets)}
+}
+
+func bucketIndex(latency time.Duration) int {
+	if latency <= histogramBase {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(latency)/float64(histogramBase)) / math.Log(histogramFactor)))
+	if i >= histogramBuckets {
+		i = histogramBuckets - 1
+	}
+	return i
+}
+
+// bucketUpper is the largest latency counted in bucket i
+func bucketUpper(i int) time.Duration {
+	return time.Duration(float64(histogramBase) * math.Pow(histogramFactor, float64(i)))
+}
+
+func (h *latencyHistogram) observe(latency time.Duration, inputChars int) {
+	h.Count = 0
+	for i := range h.Buckets {
+		h.Buckets[i] *= histogramDecay
+		h.Count += h.Buckets[i]
+	}
+	h.Buckets[bucketIndex(latency)]++
+	h.Count++
+
+	if h.Input == 0 {
+		h.Input = float64(inputChars)
+	} else {
+		h.Input = h.Input*0.9 + float64(inputChars)*0.1
+	}
+}
+
+// quantile returns the latency below which a fraction q of the requests
+// finished
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	target := q * h.Count
+	var cumulative float64
+	for i, n := range h.Buckets {
+		cumulative += n
+		if cumulative >= target {
+			return bucketUpper(i)
+		}
+	}
+	return bucketUpper(histogramBuckets - 1)
+}
+
+// TimeoutManager learns how long requests take per provider, model and
+// request kind, and derives timeouts from the tail of that distribution.
+// It is shared by all bots since latency belongs to the model server.
+type TimeoutManager struct {
+	mutex sync.Mutex
+	stats map[timeoutKey]*latencyHistogram
+	dirty bool
+}
+
+// TimeoutStat is a point-in-time view of one latency distribution
+type TimeoutStat struct {
+	Key      string
+	Samples  float64
+	P95Ms    float64
+	P99Ms    float64
+	Timeouts int64
+}
+
+func NewTimeoutManager() *TimeoutManager {
+	return &TimeoutManager{stats: make(map[timeoutKey]*latencyHistogram)}
+}
+
+// Observe records how long a request took. A request that hit its deadline
+// is recorded with the time it was given, it would have taken longer.
+func (tm *TimeoutManager) Observe(key timeoutKey, inputChars int, latency time.Duration, timedOut bool) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	h, exists := tm.stats[key]
+	if !exists {
+		h = newLatencyHistogram()
+		tm.stats[key] = h
+	}
+	h.observe(latency, inputChars)
+	if timedOut {
+		h.Timeouts++
+	}
+	tm.dirty = true
+}
+
+// Timeout returns the deadline for a request of inputChars characters. It
+// allows for twice the p95 or a quarter more than the p99, whichever is
+// longer, stretched for prompts longer than usual. Prompt processing grows
+// with the input while generation does not, so the stretch is the square
+// root of the length ratio.
+func (tm *TimeoutManager) Timeout(key timeoutKey, inputChars int) time.Duration {
+	limits, exists := kindTimeouts[key.Kind]
+	if !exists {
+		limits = kindTimeouts[RequestChat]
+	}
+
+	tm.mutex.Lock()
+	h := tm.stats[key]
+	if h == nil || h.Count < minTimeoutSamples {
+		tm.mutex.Unlock()
+		return limits.initial
+	}
+	timeout := math.Max(2*float64(h.quantile(0.95)), 1.25*float64(h.quantile(0.99)))
+	if h.Input > 0 && float64(inputChars) > h.Input {
+		timeout *= math.Min(math.Sqrt(float64(inputChars)/h.Input), maxInputScale)
+	}
+	tm.mutex.Unlock()
+
+	switch {
+	case time.Duration(timeout) < MIN_TIMEOUT:
+		return MIN_TIMEOUT
+	case time.Duration(timeout) > limits.max:
+		return limits.max
+	}
+	return time.Duration(timeout)
+}
+
+// Stats returns every latency distribution for display
+func (tm *TimeoutManager) Stats() []TimeoutStat {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	stats := make([]TimeoutStat, 0, len(tm.stats))
+	for key, h := range tm.stats {
+		stats = append(stats, TimeoutStat{
+			Key:      key.String(),
+			Samples:  h.Count,
+			P95Ms:    float64(h.quantile(0.95)) / float64(time.Millisecond),
+			P99Ms:    float64(h.quantile(0.99)) / float64(time.Millisecond),
+			Timeouts: h.Timeouts,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats
+}
+
+// export serializes the distributions that changed since the last export
+func (tm *TimeoutManager) export() (map[string][]byte, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	if !tm.dirty {
+		return nil, nil
+	}
+	states := make(map[string][]byte, len(tm.stats))
+	for key, h := range tm.stats {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return nil, err
+		}
+		states[key.String()] = data
+	}
+	tm.dirty = false
+	return states, nil
+}
+
+// load restores distributions saved by export
+func (tm *TimeoutManager) load(states map[string][]byte) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	for raw, data := range states {
+		parts := strings.SplitN(raw, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		h := newLatencyHistogram()
+		if err := json.Unmarshal(data, h); err != nil || len(h.Buckets) != histogramBuckets {
+			continue
+		}
+		tm.stats[timeoutKey{Provider: parts[0], Model: parts[1], Kind: parts[2]}] = h
+	}
+}
+
+// loadTimeouts warm-starts the timeouts from the database
+func (am *AccountManager) loadTimeouts() {
+	states, err := am.store.LoadTimeoutStates()
+	if err != nil {
+		am.logger.Warnf("Failed to load timeout stats: %v", err)
+		return
+	}
+	am.timeouts.load(states)
+}
+
+// saveTimeouts writes the latency statistics to the database
+func (am *AccountManager) saveTimeouts() {
+	states, err := am.timeouts.export()
+	if err == nil && len(states) > 0 {
+		err = am.store.SaveTimeoutStates(states)
+	}
+	if err != nil {
+		am.logger.Warnf("Failed to save timeout stats: %v", err)
+	}
+}
+
+// persistTimeouts periodically saves the latency statistics
+func (am *AccountManager) persistTimeouts() {
+	ticker := time.NewTicker(timeoutPersistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			am.saveTimeouts()
+		case <-am.done:
+			return
+		}
+	}
+}
+
+// TimeoutStats returns the learned latency of every provider, model and
+// request kind
+func (am *AccountManager) TimeoutStats() []TimeoutStat {
+	return am.timeouts.Stats()
+}
+
+// requestTimeout returns the deadline for a request of the given kind. It
+// is long enough for the slowest of the bot's endpoints, since any of them
+// may end up serving it.
+func (b *Bot) requestTimeout(kind string, inputChars int) time.Duration {
+	var timeout time.Duration
+	for _, ep := range b.endpointConfigs() {
+		if t := b.timeouts.Timeout(ep.timeoutKey(kind), inputChars); t > timeout {
+			timeout = t
+		}
+	}
+	utils.UpdateAverageTimeout(timeout)
+	return timeout
+}
+
+// promptChars returns the length of the prompt a chat request would send
+func (b *Bot) promptChars(chatID, userMsg string) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	n := len(userMsg)
+	if conv, exists := b.conversations[chatID]; exists {
+		for _, msg := range conv.Messages {
+			n += len(msg.Content)
+		}
+	}
+	return n
+}
+
+// messageChars returns the length of a request's messages
+func messageChars(messages []map[string]string) int {
+	n := 0
+	for _, msg := range messages {
+		n += len(msg["content"])
+	}
+	return n
+}
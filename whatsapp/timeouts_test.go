@@ -0,0 +1,92 @@
+package whatsapp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	tests := []struct {
+		latency time.Duration
+		want    int
+	}{
+		{0, 0},
+		{histogramBase, 0},
+		{histogramBase + 1, 1},
+		{bucketUpper(10), 10},
+		{bucketUpper(10) + time.Millisecond, 11},
+		{24 * time.Hour, histogramBuckets - 1},
+	}
+	for _, tt := range tests {
+		if got := bucketIndex(tt.latency); got != tt.want {
+			t.Errorf("bucketIndex(%v) = %d, want %d", tt.latency, got, tt.want)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// upper is the latency a sample of d is counted as
+	upper := func(d time.Duration) time.Duration { return bucketUpper(bucketIndex(d)) }
+
+	tests := []struct {
+		name       string
+		kind       string
+		samples    int
+		latency    time.Duration
+		inputChars int
+		want       time.Duration
+	}{
+		{"too few samples", RequestChat, minTimeoutSamples - 1, 5 * time.Second, 1000, INITIAL_TIMEOUT},
+		{"too few summary samples", RequestSummary, 3, 5 * time.Second, 1000, 2 * MAX_TIMEOUT},
+		{"twice the p95", RequestChat, 20, 5 * time.Second, 1000, 2 * upper(5*time.Second)},
+		{"at least the minimum", RequestChat, 20, time.Second, 1000, MIN_TIMEOUT},
+		{"capped for chat", RequestChat, 20, 50 * time.Second, 1000, MAX_TIMEOUT},
+		{"summary allows longer", RequestSummary, 20, 50 * time.Second, 1000, 2 * upper(50*time.Second)},
+		{"unknown kind as chat", "other", 20, 50 * time.Second, 1000, MAX_TIMEOUT},
+		{"longer prompt", RequestChat, 20, 5 * time.Second, 4000, 4 * upper(5*time.Second)},
+		{"much longer prompt", RequestChat, 20, 2 * time.Second, 100000, time.Duration(3 * 2 * float64(upper(2*time.Second)))},
+		{"shorter prompt", RequestChat, 20, 5 * time.Second, 10, 2 * upper(5*time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTimeoutManager()
+			key := timeoutKey{Provider: "local", Model: "m", Kind: tt.kind}
+			for i := 0; i < tt.samples; i++ {
+				tm.Observe(key, 1000, tt.latency, false)
+			}
+			if got := tm.Timeout(key, tt.inputChars); got != tt.want {
+				t.Errorf("Timeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutExportLoad(t *testing.T) {
+	tm := NewTimeoutManager()
+	key := timeoutKey{Provider: "local", Model: "m", Kind: RequestChat}
+	for i := 0; i < 20; i++ {
+		tm.Observe(key, 1000, 5*time.Second, i == 0)
+	}
+
+	states, err := tm.export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := tm.export(); again != nil {
+		t.Error("export without changes returned states")
+	}
+
+	states["broken"] = []byte("{}")
+	states["a|b|c"] = []byte(`{"buckets": [1]}`)
+	restored := NewTimeoutManager()
+	restored.load(states)
+	if len(restored.stats) != 1 {
+		t.Fatalf("restored %d distributions, want 1", len(restored.stats))
+	}
+	if got, want := restored.Timeout(key, 1000), tm.Timeout(key, 1000); got != want {
+		t.Errorf("restored Timeout = %v, want %v", got, want)
+	}
+	if got := restored.Stats()[0].Timeouts; got != 1 {
+		t.Errorf("restored %d timeouts, want 1", got)
+	}
+}
@@ -0,0 +1,152 @@
+package whatsapp
+
+import (
+	"context"
+	"sync"
+)
+
+// Supersede policies decide what happens when a contact sends a new message
+// while the previous one is still being answered
+const (
+	// SupersedeCancelMerge cancels the running generation and answers both
+	// messages together
+	SupersedeCancelMerge = "cancel_merge"
+	// SupersedeQueue answers the new message after the running one
+	SupersedeQueue = "queue"
+)
+
+// turnRegistry tracks the turns being answered in each chat
+type turnRegistry struct {
+	mutex sync.Mutex
+	// turns holds each chat's newest turn
+	turns map[string]*activeTurn
+	// active holds every running or waiting turn of each chat
+	active map[string][]*activeTurn
+}
+
+// activeTurn is one user turn being answered
+type activeTurn struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	text       string
+	done       chan struct{}
+	superseded bool
+	// committed turns are sending their reply, newer messages start a turn
+	// of their own instead of merging into them
+	committed bool
+	// interrupted turns were cancelled because a human took over the chat
+	interrupted bool
+}
+
+func newTurnRegistry() *turnRegistry {
+	return &turnRegistry{turns: make(map[string]*activeTurn), active: make(map[string][]*activeTurn)}
+}
+
+// begin registers a new turn for chatID and waits until the chat's previous
+// turn has finished. Under SupersedeCancelMerge the previous turn is
+// cancelled and its text is merged into the new one.
+func (r *turnRegistry) begin(chatID, text, policy string) *activeTurn {
+	ctx, cancel := context.WithCancel(context.Background())
+	turn := &activeTurn{
+		ctx:    ctx,
+		cancel: cancel,
+		text:   text,
+		done:   make(chan struct{}),
+	}
+
+	r.mutex.Lock()
+	prev := r.turns[chatID]
+	if prev != nil && policy != SupersedeQueue && !prev.committed {
+		prev.superseded = true
+		prev.cancel()
+		turn.text = prev.text + "\n" + text
+	}
+	r.turns[chatID] = turn
+	r.active[chatID] = append(r.active[chatID], turn)
+	r.mutex.Unlock()
+
+	if prev != nil {
+		<-prev.done
+	}
+	return turn
+}
+
+// finish releases the turn so the chat's next turn can start
+func (r *turnRegistry) finish(chatID string, turn *activeTurn) {
+	r.mutex.Lock()
+	if r.turns[chatID] == turn {
+		delete(r.turns, chatID)
+	}
+	active := r.active[chatID]
+	for i, t := range active {
+		if t == turn {
+			active = append(active[:i], active[i+1:]...)
+			break
+		}
+	}
+	if len(active) == 0 {
+		delete(r.active, chatID)
+	} else {
+		r.active[chatID] = active
+	}
+	r.mutex.Unlock()
+
+	turn.cancel()
+	close(turn.done)
+}
+
+// isSuperseded reports whether a newer message has taken over the turn
+func (r *turnRegistry) isSuperseded(turn *activeTurn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return turn.superseded
+}
+
+// commit marks the turn as replying, so no newer message merges into it
+// anymore. It reports false if a newer message has already taken the turn
+// over, the reply is stale then.
+func (r *turnRegistry) commit(turn *activeTurn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if turn.superseded {
+		return false
+	}
+	turn.committed = true
+	return true
+}
+
+// interrupt cancels every turn running or waiting in chatID without a
+// newer turn taking their place
+func (r *turnRegistry) interrupt(chatID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, turn := range r.active[chatID] {
+		turn.interrupted = true
+		turn.cancel()
+	}
+}
+
+// isInterrupted reports whether the turn was cancelled by interrupt
+func (r *turnRegistry) isInterrupted(turn *activeTurn) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return turn.interrupted
+}
+
+// dropUserMessage removes a superseded turn's prompt from the chat history,
+// it is repeated in the turn that replaced it
+func (b *Bot) dropUserMessage(chatID, text string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	conv, exists := b.conversations[chatID]
+	if !exists {
+		return
+	}
+	for i := len(conv.Messages) - 1; i >= 0; i-- {
+		if conv.Messages[i].Role == "user" && conv.Messages[i].Content == text {
+			conv.Messages = append(conv.Messages[:i], conv.Messages[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package whatsapp
+
+import "testing"
+
+func TestTurnSupersede(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         string
+		commitFirst    bool
+		wantSuperseded bool
+		wantText       string
+	}{
+		{"cancel and merge", SupersedeCancelMerge, false, true, "first\nsecond"},
+		{"default merges", "", false, true, "first\nsecond"},
+		{"queue", SupersedeQueue, false, false, "second"},
+		{"committed turn is not merged", SupersedeCancelMerge, true, false, "second"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTurnRegistry()
+			first := r.begin("chat", "first", tt.policy)
+			if tt.commitFirst && !r.commit(first) {
+				t.Fatal("commit of the only turn failed")
+			}
+
+			began := make(chan *activeTurn)
+			go func() { began <- r.begin("chat", "second", tt.policy) }()
+			waitFor(t, func() bool {
+				r.mutex.Lock()
+				defer r.mutex.Unlock()
+				return r.turns["chat"] != first
+			})
+
+			if got := r.isSuperseded(first); got != tt.wantSuperseded {
+				t.Errorf("first superseded = %v, want %v", got, tt.wantSuperseded)
+			}
+			if cancelled := first.ctx.Err() != nil; cancelled != tt.wantSuperseded {
+				t.Errorf("first cancelled = %v, want %v", cancelled, tt.wantSuperseded)
+			}
+			// A superseded turn's reply is stale
+			if got := r.commit(first); got != !tt.wantSuperseded {
+				t.Errorf("commit of the first turn = %v, want %v", got, !tt.wantSuperseded)
+			}
+
+			select {
+			case <-began:
+				t.Fatal("second turn began before the first finished")
+			default:
+			}
+			r.finish("chat", first)
+			second := <-began
+			if second.text != tt.wantText {
+				t.Errorf("second turn text = %q, want %q", second.text, tt.wantText)
+			}
+			if second.ctx.Err() != nil {
+				t.Error("second turn is cancelled")
+			}
+			r.finish("chat", second)
+			if len(r.turns) != 0 || len(r.active) != 0 {
+				t.Errorf("registry not empty after both turns finished: %v, %v", r.turns, r.active)
+			}
+		})
+	}
+}
+
+func TestTurnInterrupt(t *testing.T) {
+	r := newTurnRegistry()
+	first := r.begin("chat", "first", SupersedeQueue)
+	other := r.begin("other", "hi", SupersedeQueue)
+
+	r.interrupt("chat")
+	if !r.isInterrupted(first) || first.ctx.Err() == nil {
+		t.Error("turn was not interrupted")
+	}
+	if r.isSuperseded(first) {
+		t.Error("interrupted turn counts as superseded")
+	}
+	if r.isInterrupted(other) || other.ctx.Err() != nil {
+		t.Error("interrupt reached another chat")
+	}
+	r.finish("chat", first)
+	r.finish("other", other)
+}